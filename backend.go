@@ -0,0 +1,19 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/AstromechZA/inotify-spy/backend"
+)
+
+// openBackend sets up the named backend, falling back to inotify when
+// fanotify can't be used.
+func openBackend(name string, opts backend.Options) (backend.Backend, error) {
+    b, err := backend.New(name, opts)
+    if err != nil && name == "fanotify" {
+        fmt.Fprintf(status, "Could not use fanotify, falling back to inotify: %v\n", err.Error())
+        fmt.Fprintln(status, "fanotify needs a linux kernel built with it and CAP_SYS_ADMIN, try running as root.")
+        return backend.New("inotify", opts)
+    }
+    return b, err
+}
@@ -0,0 +1,83 @@
+package backend
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// Backend is a source of file events for a capture. Paths are added and
+// removed one directory at a time, like fsnotify.Watcher.
+type Backend interface {
+    Add(name string) error
+    Remove(name string) error
+    // WatchList returns the paths currently being watched.
+    WatchList() []string
+
+    Events() <-chan fsnotify.Event
+    // Errors carries fsnotify.ErrEventOverflow when events were dropped.
+    Errors() <-chan error
+
+    Capabilities() Capabilities
+    Close() error
+}
+
+// MountWatcher is implemented by backends that can watch a whole mount, or
+// filesystem, with a single mark, reporting only the events below name.
+type MountWatcher interface {
+    AddMount(name string, filesystem bool) error
+}
+
+// Capabilities says what a backend is able to report.
+type Capabilities struct {
+    // Ops are the operations the backend can report.
+    Ops fsnotify.Op
+}
+
+// Supports checks that every op in op can be reported.
+func (c Capabilities) Supports(op fsnotify.Op) bool {
+    return c.Ops & op == op
+}
+
+// Options holds the settings of every backend, each uses the ones it needs.
+type Options struct {
+    // PollInterval is how often the polling backend scans.
+    PollInterval time.Duration
+    // PollMaxFiles caps the files the polling backend looks at in a scan, zero for no cap.
+    PollMaxFiles int
+}
+
+var constructors = make(map[string]func(Options) (Backend, error))
+
+// Register makes a backend available to New under a name.
+func Register(name string, constructor func(Options) (Backend, error)) {
+    constructors[name] = constructor
+}
+
+// Names lists the registered backends.
+func Names() []string {
+    var names []string
+    for name := range constructors {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Exists checks whether a backend has been registered under name.
+func Exists(name string) bool {
+    _, ok := constructors[name]
+    return ok
+}
+
+// New sets up the named backend.
+func New(name string, opts Options) (Backend, error) {
+    constructor, ok := constructors[name]
+    if ok == false {
+        return nil, fmt.Errorf("unknown backend %v, expected one of %v", name, strings.Join(Names(), ", "))
+    }
+    return constructor(opts)
+}
@@ -0,0 +1,31 @@
+package backend
+
+import (
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fanotify"
+)
+
+// Fanotify adapts fanotify.Watcher, which knows the process behind each event.
+type Fanotify struct {
+    *fanotify.Watcher
+}
+
+func init() {
+    Register("fanotify", func(Options) (Backend, error) { return NewFanotify() })
+}
+
+func NewFanotify() (*Fanotify, error) {
+    w, err := fanotify.NewWatcher()
+    if err != nil { return nil, err }
+    return &Fanotify{w}, nil
+}
+
+func (b *Fanotify) Events() <-chan fsnotify.Event { return b.Watcher.Events }
+func (b *Fanotify) Errors() <-chan error { return b.Watcher.Errors }
+
+func (b *Fanotify) Capabilities() Capabilities {
+    return Capabilities{
+        Ops: fsnotify.Open | fsnotify.Write | fsnotify.Access | fsnotify.CloseWrite | fsnotify.CloseNoWrite,
+    }
+}
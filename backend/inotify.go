@@ -0,0 +1,28 @@
+package backend
+
+import (
+    "github.com/fsnotify/fsnotify"
+)
+
+// Inotify adapts fsnotify.Watcher, which uses inotify on linux and the
+// closest equivalent elsewhere.
+type Inotify struct {
+    *fsnotify.Watcher
+}
+
+func init() {
+    Register("inotify", func(Options) (Backend, error) { return NewInotify() })
+}
+
+func NewInotify() (*Inotify, error) {
+    w, err := fsnotify.NewWatcher()
+    if err != nil { return nil, err }
+    return &Inotify{w}, nil
+}
+
+func (b *Inotify) Events() <-chan fsnotify.Event { return b.Watcher.Events }
+func (b *Inotify) Errors() <-chan error { return b.Watcher.Errors }
+
+func (b *Inotify) Capabilities() Capabilities {
+    return Capabilities{Ops: inotifyOps}
+}
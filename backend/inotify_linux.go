@@ -0,0 +1,8 @@
+package backend
+
+import (
+    "github.com/fsnotify/fsnotify"
+)
+
+const inotifyOps = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename | fsnotify.Chmod |
+    fsnotify.Open | fsnotify.CloseWrite | fsnotify.CloseNoWrite | fsnotify.Access
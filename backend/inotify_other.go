@@ -0,0 +1,10 @@
+// +build !linux
+
+package backend
+
+import (
+    "github.com/fsnotify/fsnotify"
+)
+
+// kqueue and ReadDirectoryChangesW can't see files being opened, read or closed
+const inotifyOps = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename | fsnotify.Chmod
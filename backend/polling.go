@@ -0,0 +1,29 @@
+package backend
+
+import (
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/polling"
+)
+
+// Polling adapts polling.Watcher, for filesystems that inotify can't see into.
+type Polling struct {
+    *polling.Watcher
+}
+
+func init() {
+    Register("polling", func(opts Options) (Backend, error) { return NewPolling(opts) })
+}
+
+func NewPolling(opts Options) (*Polling, error) {
+    w, err := polling.NewWatcher(opts.PollInterval, opts.PollMaxFiles)
+    if err != nil { return nil, err }
+    return &Polling{w}, nil
+}
+
+func (b *Polling) Events() <-chan fsnotify.Event { return b.Watcher.Events }
+func (b *Polling) Errors() <-chan error { return b.Watcher.Errors }
+
+func (b *Polling) Capabilities() Capabilities {
+    return Capabilities{Ops: fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Chmod}
+}
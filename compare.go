@@ -0,0 +1,48 @@
+package main
+
+import (
+    "bufio"
+    "os"
+    "strings"
+
+    "github.com/AstromechZA/inotify-spy/eventbox"
+    "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/summary"
+)
+
+// isSessionFile checks whether path starts like a file written by -record
+// rather than a csv export.
+func isSessionFile(path string) (bool, error) {
+    f, err := os.Open(path)
+    if err != nil { return false, err }
+    defer f.Close()
+
+    line, err := bufio.NewReader(f).ReadString('\n')
+    if err != nil && line == "" { return false, err }
+    return strings.HasPrefix(line, "# inotify-spy session"), nil
+}
+
+// loadCapture reads either a recorded session or a csv export into per-path
+// counts, applying the ignore prefixes. It also returns the recorded ops the
+// capture has counts for, since a csv only holds the columns it was exported with.
+func loadCapture(path string, recordMask uint, ignorePrefixes *[]string) (map[string]fileevents.FileWithEvents, uint, error) {
+    isSession, err := isSessionFile(path)
+    if err != nil { return nil, 0, err }
+
+    if isSession {
+        box := eventbox.NewEventBox()
+        info, err := replaySession(path, box, recordMask, ignorePrefixes)
+        if err != nil { return nil, 0, err }
+        // only the ops the session recorded were written to it
+        return box.Data, info.RecordMask & recordMask, nil
+    }
+
+    fevents, opMask, err := summary.ReadCSV(path)
+    if err != nil { return nil, 0, err }
+    data := make(map[string]fileevents.FileWithEvents)
+    for _, v := range fevents {
+        if mustIgnorePath(v.Name, ignorePrefixes) { continue }
+        data[v.Name] = v
+    }
+    return data, opMask & recordMask, nil
+}
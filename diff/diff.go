@@ -0,0 +1,213 @@
+package diff
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/summary"
+)
+
+const (
+    Added = "added"
+    Removed = "removed"
+    Changed = "changed"
+)
+
+// PathDiff describes how the recorded events for one path differ between two
+// captures.
+type PathDiff struct {
+    Name string
+    Change string
+    Before map[fsnotify.Op]int
+    After map[fsnotify.Op]int
+}
+
+// Delta is the change in count for an op, after minus before.
+func (d PathDiff) Delta(op fsnotify.Op) int {
+    return d.After[op] - d.Before[op]
+}
+
+// TotalDelta is the sum of the absolute deltas, used to rank paths.
+func (d PathDiff) TotalDelta() int {
+    total := 0
+    for op := range opsOf(d) {
+        delta := d.Delta(op)
+        if delta < 0 { delta = -delta }
+        total += delta
+    }
+    return total
+}
+
+func opsOf(d PathDiff) map[fsnotify.Op]bool {
+    ops := make(map[fsnotify.Op]bool)
+    for op := range d.Before { ops[op] = true }
+    for op := range d.After { ops[op] = true }
+    return ops
+}
+
+type ByTotalDelta []PathDiff
+func (a ByTotalDelta) Len() int {return len(a)}
+func (a ByTotalDelta) Swap(i, j int) {a[i], a[j] = a[j], a[i]}
+func (a ByTotalDelta) Less(i, j int) bool {return a[i].TotalDelta() > a[j].TotalDelta()}
+
+type ByName []PathDiff
+func (a ByName) Len() int {return len(a)}
+func (a ByName) Swap(i, j int) {a[i], a[j] = a[j], a[i]}
+func (a ByName) Less(i, j int) bool {return strings.Compare(a[i].Name, a[j].Name) < 0}
+
+// maskedCounts keeps only the counts for the recorded ops, dropping zeroes.
+func maskedCounts(f fileevents.FileWithEvents, ops []fsnotify.Op) map[fsnotify.Op]int {
+    counts := make(map[fsnotify.Op]int)
+    for _, op := range ops {
+        if f.Events[op] != 0 {
+            counts[op] = f.Events[op]
+        }
+    }
+    return counts
+}
+
+// Compare returns the paths whose recorded ops differ between the captures.
+// Paths only touched in one of them are added or removed.
+func Compare(before, after map[string]fileevents.FileWithEvents, recordMask uint) []PathDiff {
+    ops := summary.RecordedOps(recordMask)
+
+    names := make(map[string]bool)
+    for name := range before { names[name] = true }
+    for name := range after { names[name] = true }
+
+    var diffs []PathDiff
+    for name := range names {
+        d := PathDiff{
+            Name: name,
+            Before: maskedCounts(before[name], ops),
+            After: maskedCounts(after[name], ops),
+        }
+        switch {
+        case len(d.Before) == 0 && len(d.After) == 0:
+            continue
+        case len(d.Before) == 0:
+            d.Change = Added
+        case len(d.After) == 0:
+            d.Change = Removed
+        default:
+            if d.TotalDelta() == 0 { continue }
+            d.Change = Changed
+        }
+        diffs = append(diffs, d)
+    }
+    return diffs
+}
+
+func formatDelta(delta int) string {
+    if delta > 0 { return "+" + strconv.Itoa(delta) }
+    return strconv.Itoa(delta)
+}
+
+type jsonPathDiff struct {
+    Path string `json:"path"`
+    Change string `json:"change"`
+    Before map[string]int `json:"before"`
+    After map[string]int `json:"after"`
+    Delta map[string]int `json:"delta"`
+}
+
+type jsonDiff struct {
+    Before string `json:"before"`
+    After string `json:"after"`
+    Paths []jsonPathDiff `json:"paths"`
+}
+
+// DoDiff prints the differences between two captures, using the same column
+// layout as the summary with signed deltas in place of counts.
+func DoDiff(beforeName string, before map[string]fileevents.FileWithEvents, afterName string, after map[string]fileevents.FileWithEvents, recordMask uint, sortByName bool, exportCSV string, exportJSON string) error {
+
+    fmt.Println()
+    fmt.Printf("Comparing %s (before) with %s (after)\n", beforeName, afterName)
+    fmt.Println()
+
+    ops := summary.RecordedOps(recordMask)
+    diffs := Compare(before, after, recordMask)
+    if sortByName {
+        sort.Sort(ByName(diffs))
+    } else {
+        sort.Sort(ByTotalDelta(diffs))
+    }
+
+    for _, op := range ops {
+        fmt.Printf("%-*s", summary.ColumnWidth(op), fileevents.OpName(op))
+    }
+    fmt.Printf("%-8s", "Change")
+    fmt.Println("Path")
+
+    for _, d := range diffs {
+        for _, op := range ops {
+            fmt.Printf("%-*s", summary.ColumnWidth(op), formatDelta(d.Delta(op)))
+        }
+        fmt.Printf("%-8s", d.Change)
+        fmt.Println(d.Name)
+    }
+
+    if len(diffs) == 0 {
+        fmt.Println("No differences.")
+    }
+
+    if exportCSV != "" {
+        fmt.Println("Writing CSV to", exportCSV)
+
+        content := ""
+        for _, op := range ops {
+            content += fileevents.OpName(op) + ","
+        }
+        content += "Change,Path\n"
+
+        for _, d := range diffs {
+            for _, op := range ops {
+                content += formatDelta(d.Delta(op)) + ","
+            }
+            content += d.Change + "," + d.Name + "\n"
+        }
+
+        err := ioutil.WriteFile(exportCSV, []byte(content), 0644)
+        if err != nil {
+            return err
+        }
+    }
+
+    if exportJSON != "" {
+        fmt.Println("Writing JSON to", exportJSON)
+
+        doc := jsonDiff{Before: beforeName, After: afterName, Paths: []jsonPathDiff{}}
+        for _, d := range diffs {
+            jd := jsonPathDiff{
+                Path: d.Name,
+                Change: d.Change,
+                Before: make(map[string]int),
+                After: make(map[string]int),
+                Delta: make(map[string]int),
+            }
+            for _, op := range ops {
+                jd.Before[fileevents.OpName(op)] = d.Before[op]
+                jd.After[fileevents.OpName(op)] = d.After[op]
+                jd.Delta[fileevents.OpName(op)] = d.Delta(op)
+            }
+            doc.Paths = append(doc.Paths, jd)
+        }
+
+        content, err := json.MarshalIndent(doc, "", "  ")
+        if err != nil {
+            return err
+        }
+        err = ioutil.WriteFile(exportJSON, append(content, '\n'), 0644)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,115 @@
+package diff
+
+import (
+    "sort"
+    "testing"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+func capture(files ...fileevents.FileWithEvents) map[string]fileevents.FileWithEvents {
+    data := make(map[string]fileevents.FileWithEvents)
+    for _, f := range files {
+        data[f.Name] = f
+    }
+    return data
+}
+
+func file(name string, events map[fsnotify.Op]int) fileevents.FileWithEvents {
+    return fileevents.FileWithEvents{Name: name, Events: events}
+}
+
+func TestCompare(t *testing.T) {
+    all := fileevents.AllOpsMask()
+    tests := []struct {
+        name string
+        before map[string]fileevents.FileWithEvents
+        after map[string]fileevents.FileWithEvents
+        recordMask uint
+        want map[string]string
+        deltas map[string]map[fsnotify.Op]int
+    }{
+        {
+            name: "added",
+            before: capture(),
+            after: capture(file("/a", map[fsnotify.Op]int{fsnotify.Create: 1})),
+            recordMask: all,
+            want: map[string]string{"/a": Added},
+            deltas: map[string]map[fsnotify.Op]int{"/a": {fsnotify.Create: 1}},
+        },
+        {
+            name: "removed",
+            before: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 3})),
+            after: capture(),
+            recordMask: all,
+            want: map[string]string{"/a": Removed},
+            deltas: map[string]map[fsnotify.Op]int{"/a": {fsnotify.Write: -3}},
+        },
+        {
+            name: "changed",
+            before: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 3, fsnotify.Open: 1})),
+            after: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 1, fsnotify.Open: 1, fsnotify.Remove: 1})),
+            recordMask: all,
+            want: map[string]string{"/a": Changed},
+            deltas: map[string]map[fsnotify.Op]int{"/a": {fsnotify.Write: -2, fsnotify.Open: 0, fsnotify.Remove: 1}},
+        },
+        {
+            name: "unchanged paths are left out",
+            before: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 2})),
+            after: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 2})),
+            recordMask: all,
+            want: map[string]string{},
+        },
+        {
+            name: "zero counts don't make a path present",
+            before: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 0})),
+            after: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 1})),
+            recordMask: all,
+            want: map[string]string{"/a": Added},
+        },
+        {
+            name: "ops outside the mask are ignored",
+            before: capture(file("/a", map[fsnotify.Op]int{fsnotify.Open: 5, fsnotify.Write: 1})),
+            after: capture(file("/a", map[fsnotify.Op]int{fsnotify.Write: 1}), file("/b", map[fsnotify.Op]int{fsnotify.Open: 1})),
+            recordMask: uint(fsnotify.Write),
+            want: map[string]string{},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            diffs := Compare(tt.before, tt.after, tt.recordMask)
+            sort.Sort(ByName(diffs))
+            got := make(map[string]string)
+            for _, d := range diffs {
+                got[d.Name] = d.Change
+                for op, want := range tt.deltas[d.Name] {
+                    if d.Delta(op) != want {
+                        t.Errorf("%s %s: delta %d, want %d", d.Name, fileevents.OpName(op), d.Delta(op), want)
+                    }
+                }
+            }
+            if len(got) != len(tt.want) {
+                t.Fatalf("got %v, want %v", got, tt.want)
+            }
+            for name, change := range tt.want {
+                if got[name] != change {
+                    t.Errorf("%s: got %q, want %q", name, got[name], change)
+                }
+            }
+        })
+    }
+}
+
+func TestRankByTotalDelta(t *testing.T) {
+    diffs := Compare(
+        capture(file("/small", map[fsnotify.Op]int{fsnotify.Write: 1}), file("/big", map[fsnotify.Op]int{fsnotify.Write: 10})),
+        capture(file("/small", map[fsnotify.Op]int{fsnotify.Write: 2}), file("/big", map[fsnotify.Op]int{fsnotify.Write: 1})),
+        fileevents.AllOpsMask(),
+    )
+    sort.Sort(ByTotalDelta(diffs))
+    if len(diffs) != 2 || diffs[0].Name != "/big" || diffs[0].TotalDelta() != 9 {
+        t.Errorf("got %+v, want /big first with a total delta of 9", diffs)
+    }
+}
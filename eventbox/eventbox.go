@@ -2,14 +2,18 @@ package eventbox
 
 import (
     "sync"
+    "time"
     "github.com/fsnotify/fsnotify"
 
     "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/procinfo"
 )
 
 type EventBox struct {
     lock sync.Mutex
     Data map[string]fileevents.FileWithEvents
+    Moves []fileevents.Move
+    Overflows int
 }
 
 func NewEventBox() *EventBox {
@@ -22,18 +26,97 @@ func NewEventBox() *EventBox {
 func (b *EventBox) Add(e *fsnotify.Event) {
     b.lock.Lock()
     defer b.lock.Unlock()
+    b.count(e.Name, e.Op, e.Time)
+    if e.Pid != 0 {
+        fevent := b.Data[e.Name]
+        if fevent.Processes == nil { fevent.Processes = make(map[string]int) }
+        fevent.Processes[procinfo.Lookup(e.Pid).String()]++
+        b.Data[e.Name] = fevent
+    }
+}
+
+// AddMove records a move and counts it against both of its paths.
+func (b *EventBox) AddMove(m fileevents.Move) {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    b.Moves = append(b.Moves, m)
+    if m.From != "" { b.count(m.From, m.Op(), m.Time) }
+    if m.To != "" { b.count(m.To, m.Op(), m.Time) }
+}
+
+// AddOverflow records that the kernel dropped events, so the counts are incomplete.
+func (b *EventBox) AddOverflow() {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+    b.Overflows++
+}
+
+// Snapshot returns a copy of the box that is safe to read while events are
+// still being added to the original.
+func (b *EventBox) Snapshot() *EventBox {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    snap := NewEventBox()
+    for name, v := range b.Data {
+        events := make(map[fsnotify.Op]int)
+        for op, count := range v.Events {
+            events[op] = count
+        }
+        v.Events = events
+        if v.Processes != nil {
+            processes := make(map[string]int)
+            for p, count := range v.Processes {
+                processes[p] = count
+            }
+            v.Processes = processes
+        }
+        snap.Data[name] = v
+    }
+    snap.Moves = append([]fileevents.Move(nil), b.Moves...)
+    snap.Overflows = b.Overflows
+    return snap
+}
+
+// Reset forgets everything recorded so far.
+func (b *EventBox) Reset() {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    b.Data = make(map[string]fileevents.FileWithEvents)
+    b.Moves = nil
+    b.Overflows = 0
+}
+
+// TimeRange returns the earliest first-seen and latest last-seen times across
+// all paths, or zero times if nothing was recorded.
+func (b *EventBox) TimeRange() (time.Time, time.Time) {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    var first, last time.Time
+    for _, v := range b.Data {
+        if first.IsZero() || v.FirstSeen.Before(first) { first = v.FirstSeen }
+        if v.LastSeen.After(last) { last = v.LastSeen }
+    }
+    return first, last
+}
 
-    fevent, ok := b.Data[e.Name]
+func (b *EventBox) count(name string, op fsnotify.Op, t time.Time) {
+    fevent, ok := b.Data[name]
     if ok == false {
         fevent = fileevents.FileWithEvents{
-            Name: e.Name,
+            Name: name,
             Events: make(map[fsnotify.Op]int),
             Total: 0,
+            FirstSeen: t,
         }
     }
 
-    count := fevent.Events[e.Op]
-    fevent.Events[e.Op] = count + 1
+    count := fevent.Events[op]
+    fevent.Events[op] = count + 1
     fevent.Total++
-    b.Data[e.Name] = fevent
+    fevent.LastSeen = t
+    b.Data[name] = fevent
 }
@@ -0,0 +1,225 @@
+package exechook
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+// What to do with a command that is ready to run while the concurrency limit
+// is reached.
+const (
+    ModeQueue = "queue"
+    ModeDrop = "drop"
+    ModeRestart = "restart"
+)
+
+type Config struct {
+    // Command is run with sh -c, with the path and ops as $1 and $2
+    Command string
+    // Ops that trigger the command
+    Ops fsnotify.Op
+    // Patterns are globs matched against the full path or its base name, any path matches if empty
+    Patterns []string
+    // Debounce is how long a path has to be quiet before its command runs
+    Debounce time.Duration
+    // Concurrency is the most commands running at once
+    Concurrency int
+    // Mode is one of ModeQueue, ModeDrop or ModeRestart
+    Mode string
+}
+
+type job struct {
+    path string
+    ops fsnotify.Op
+}
+
+// Runner runs the configured command for matching events. Bursts of events
+// for the same path are debounced into a single run.
+type Runner struct {
+    cfg Config
+    out io.Writer
+
+    mu sync.Mutex
+    closed bool
+    pending map[string]fsnotify.Op
+    timers map[string]*time.Timer
+    queue []job
+    running []*exec.Cmd
+    wg sync.WaitGroup
+}
+
+// NewRunner creates a runner that sends command output and its own messages to out.
+func NewRunner(cfg Config, out io.Writer) (*Runner, error) {
+    if cfg.Concurrency < 1 {
+        return nil, fmt.Errorf("exec concurrency must be at least 1")
+    }
+    switch cfg.Mode {
+    case ModeQueue, ModeDrop, ModeRestart:
+    default:
+        return nil, fmt.Errorf("unknown exec mode %q, expected %s, %s or %s", cfg.Mode, ModeQueue, ModeDrop, ModeRestart)
+    }
+    for _, pattern := range cfg.Patterns {
+        if _, err := filepath.Match(pattern, ""); err != nil {
+            return nil, fmt.Errorf("bad exec pattern %q: %s", pattern, err.Error())
+        }
+    }
+    return &Runner{
+        cfg: cfg,
+        out: out,
+        pending: make(map[string]fsnotify.Op),
+        timers: make(map[string]*time.Timer),
+    }, nil
+}
+
+func (r *Runner) matches(path string, op fsnotify.Op) bool {
+    if r.cfg.Ops & op == 0 { return false }
+    if len(r.cfg.Patterns) == 0 { return true }
+    for _, pattern := range r.cfg.Patterns {
+        if ok, _ := filepath.Match(pattern, path); ok { return true }
+        if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok { return true }
+    }
+    return false
+}
+
+// Event notes an accepted event. It never blocks, the command runs once the
+// path has been quiet for the debounce period.
+func (r *Runner) Event(path string, op fsnotify.Op) {
+    if r.matches(path, op) == false { return }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.closed { return }
+
+    r.pending[path] |= op & r.cfg.Ops
+    if timer, ok := r.timers[path]; ok {
+        // a timer that already fired has a fire call waiting on the lock,
+        // which picks up this op, re-arming it would run the command twice
+        if timer.Stop() { timer.Reset(r.cfg.Debounce) }
+        return
+    }
+    r.timers[path] = time.AfterFunc(r.cfg.Debounce, func() { r.fire(path) })
+}
+
+func (r *Runner) fire(path string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.closed { return }
+    ops, ok := r.pending[path]
+    if ok == false { return }
+
+    j := job{path: path, ops: ops}
+    delete(r.pending, path)
+    delete(r.timers, path)
+
+    if len(r.running) < r.cfg.Concurrency {
+        r.start(j)
+        return
+    }
+    switch r.cfg.Mode {
+    case ModeQueue:
+        r.queue = append(r.queue, j)
+    case ModeDrop:
+        fmt.Fprintf(r.out, "exec: dropped run for %v, %d already running\n", path, len(r.running))
+    case ModeRestart:
+        oldest := r.running[0]
+        r.running = r.running[1:]
+        killProcess(oldest)
+        r.start(j)
+    }
+}
+
+// start runs a job, the caller must hold r.mu.
+func (r *Runner) start(j job) {
+    ops := strings.Join(fileevents.OpNames(j.ops), ",")
+    cmd := exec.Command("/bin/sh", "-c", r.cfg.Command, "inotify-spy", j.path, ops)
+    cmd.Env = append(os.Environ(), "INOTIFY_SPY_PATH=" + j.path, "INOTIFY_SPY_OPS=" + ops)
+    cmd.Stdout = r.out
+    cmd.Stderr = r.out
+    setProcessGroup(cmd)
+
+    err := cmd.Start()
+    if err != nil {
+        fmt.Fprintf(r.out, "exec: could not run command for %v: %v\n", j.path, err.Error())
+        return
+    }
+    r.running = append(r.running, cmd)
+    r.wg.Add(1)
+    go r.wait(cmd, j)
+}
+
+func (r *Runner) wait(cmd *exec.Cmd, j job) {
+    defer r.wg.Done()
+    err := cmd.Wait()
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for i, c := range r.running {
+        if c == cmd {
+            r.running = append(r.running[:i], r.running[i+1:]...)
+            break
+        }
+    }
+    if err != nil {
+        fmt.Fprintf(r.out, "exec: command for %v failed: %v\n", j.path, err.Error())
+    }
+
+    if r.closed == false && len(r.queue) > 0 && len(r.running) < r.cfg.Concurrency {
+        next := r.queue[0]
+        r.queue = r.queue[1:]
+        r.start(next)
+    }
+}
+
+// Close stops any further runs, dropping debounced and queued ones. Commands
+// that are already running are left to Terminate or Kill.
+func (r *Runner) Close() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.closed = true
+    for _, timer := range r.timers {
+        timer.Stop()
+    }
+    r.queue = nil
+}
+
+// Terminate asks the running commands to exit and waits for them, killing
+// the ones still running after grace.
+func (r *Runner) Terminate(grace time.Duration) {
+    r.Close()
+    r.mu.Lock()
+    for _, cmd := range r.running {
+        terminateProcess(cmd)
+    }
+    r.mu.Unlock()
+
+    done := make(chan bool)
+    go func() {
+        r.wg.Wait()
+        close(done)
+    }()
+    select {
+    case <- done:
+        return
+    case <- time.After(grace):
+    }
+    r.Kill()
+    <- done
+}
+
+// Kill kills the running commands straight away.
+func (r *Runner) Kill() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, cmd := range r.running {
+        killProcess(cmd)
+    }
+}
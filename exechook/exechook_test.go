@@ -0,0 +1,145 @@
+// +build !windows
+
+package exechook
+
+import (
+    "bytes"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    "github.com/fsnotify/fsnotify"
+)
+
+// syncBuffer collects the output of commands running at the same time.
+type syncBuffer struct {
+    mu sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.String()
+}
+
+// waitFor waits until the output holds count copies of want.
+func waitFor(t *testing.T, out *syncBuffer, want string, count int) {
+    deadline := time.Now().Add(5 * time.Second)
+    for strings.Count(out.String(), want) < count {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %d of %q in %q", count, want, out.String())
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func newRunner(t *testing.T, cfg Config, out *syncBuffer) *Runner {
+    if cfg.Ops == 0 { cfg.Ops = fsnotify.Create | fsnotify.Write | fsnotify.Remove }
+    if cfg.Concurrency == 0 { cfg.Concurrency = 1 }
+    if cfg.Mode == "" { cfg.Mode = ModeQueue }
+    r, err := NewRunner(cfg, out)
+    if err != nil { t.Fatal(err) }
+    return r
+}
+
+func TestDebounce(t *testing.T) {
+    out := &syncBuffer{}
+    r := newRunner(t, Config{Command: `echo "ran $1 $2"`, Debounce: 100 * time.Millisecond}, out)
+    defer r.Terminate(time.Second)
+
+    // a burst of events for one path is a single run with all of its ops
+    r.Event("/tmp/a", fsnotify.Create)
+    time.Sleep(30 * time.Millisecond)
+    r.Event("/tmp/a", fsnotify.Write)
+    time.Sleep(30 * time.Millisecond)
+    r.Event("/tmp/a", fsnotify.Write)
+    waitFor(t, out, "ran /tmp/a Create,Write", 1)
+
+    time.Sleep(200 * time.Millisecond)
+    if n := strings.Count(out.String(), "ran "); n != 1 {
+        t.Errorf("got %d runs in %q, want 1", n, out.String())
+    }
+}
+
+func TestFilters(t *testing.T) {
+    out := &syncBuffer{}
+    r := newRunner(t, Config{
+        Command: `echo "ran $1"`,
+        Ops: fsnotify.Write,
+        Patterns: []string{"*.go"},
+        Debounce: 10 * time.Millisecond,
+    }, out)
+    defer r.Terminate(time.Second)
+
+    r.Event("/tmp/skipped.txt", fsnotify.Write)
+    r.Event("/tmp/skipped.go", fsnotify.Remove)
+    r.Event("/tmp/main.go", fsnotify.Write)
+    waitFor(t, out, "ran /tmp/main.go", 1)
+    time.Sleep(50 * time.Millisecond)
+    if strings.Contains(out.String(), "skipped") {
+        t.Errorf("ran for an event that doesn't match: %q", out.String())
+    }
+}
+
+func TestModes(t *testing.T) {
+    tests := []struct {
+        mode string
+        want []string
+        notWant string
+    }{
+        {ModeQueue, []string{"done /tmp/a", "done /tmp/b"}, "dropped"},
+        {ModeDrop, []string{"done /tmp/a", "dropped run for /tmp/b"}, "done /tmp/b"},
+        {ModeRestart, []string{"done /tmp/b"}, "done /tmp/a"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.mode, func(t *testing.T) {
+            out := &syncBuffer{}
+            r := newRunner(t, Config{Command: `sleep 0.3; echo "done $1"`, Debounce: 10 * time.Millisecond, Mode: tt.mode}, out)
+            defer r.Terminate(time.Second)
+
+            r.Event("/tmp/a", fsnotify.Write)
+            time.Sleep(100 * time.Millisecond)
+            r.Event("/tmp/b", fsnotify.Write)
+            for _, want := range tt.want {
+                waitFor(t, out, want, 1)
+            }
+            time.Sleep(400 * time.Millisecond)
+            if strings.Contains(out.String(), tt.notWant) {
+                t.Errorf("got %q, didn't want %q", out.String(), tt.notWant)
+            }
+        })
+    }
+}
+
+func TestTerminate(t *testing.T) {
+    out := &syncBuffer{}
+    r := newRunner(t, Config{Command: `trap "" TERM; sleep 5; echo finished`, Debounce: 10 * time.Millisecond}, out)
+
+    r.Event("/tmp/a", fsnotify.Write)
+    time.Sleep(200 * time.Millisecond)
+    r.Event("/tmp/b", fsnotify.Write)
+
+    // a command that ignores SIGTERM is killed once the grace period is over
+    start := time.Now()
+    r.Terminate(200 * time.Millisecond)
+    if took := time.Since(start); took > 2 * time.Second {
+        t.Errorf("Terminate took %v", took)
+    }
+    if strings.Contains(out.String(), "finished") {
+        t.Errorf("the command ran to the end: %q", out.String())
+    }
+
+    // nothing runs after closing
+    r.Event("/tmp/c", fsnotify.Write)
+    time.Sleep(100 * time.Millisecond)
+    if strings.Contains(out.String(), "/tmp/b") || strings.Contains(out.String(), "/tmp/c") {
+        t.Errorf("ran after Terminate: %q", out.String())
+    }
+}
@@ -0,0 +1,22 @@
+// +build !windows
+
+package exechook
+
+import (
+    "os/exec"
+    "syscall"
+)
+
+// setProcessGroup puts the command in its own process group so that anything
+// it starts can be killed along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func terminateProcess(cmd *exec.Cmd) {
+    syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func killProcess(cmd *exec.Cmd) {
+    syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
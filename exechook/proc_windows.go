@@ -0,0 +1,16 @@
+package exechook
+
+import (
+    "os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// there is no gentler way to ask a process to exit
+func terminateProcess(cmd *exec.Cmd) {
+    cmd.Process.Kill()
+}
+
+func killProcess(cmd *exec.Cmd) {
+    cmd.Process.Kill()
+}
@@ -0,0 +1,200 @@
+package fanotify
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unsafe"
+
+    "github.com/fsnotify/fsnotify"
+    "golang.org/x/sys/unix"
+
+    "github.com/AstromechZA/inotify-spy/procinfo"
+)
+
+// the events fanotify can report without FAN_REPORT_FID
+const watchMask = unix.FAN_OPEN | unix.FAN_MODIFY | unix.FAN_ACCESS |
+    unix.FAN_CLOSE_WRITE | unix.FAN_CLOSE_NOWRITE
+
+// Watcher reports events on the files in a set of directories, like
+// fsnotify.Watcher, along with the pid of the process behind each one. It
+// needs CAP_SYS_ADMIN, and can't see creates, removes, renames or chmods.
+type Watcher struct {
+    Events chan fsnotify.Event
+    Errors chan error
+
+    fd int
+    file *os.File
+    self int
+
+    mu sync.Mutex
+    // marks maps each watched path to the kind of mark on it
+    marks map[string]uint
+    // roots maps each mount and filesystem mark to the tree its events are
+    // limited to, with symlinks resolved like the paths of the events
+    roots map[string]string
+}
+
+// NewWatcher sets up a fanotify group, failing when the kernel doesn't
+// support fanotify or the process isn't allowed to use it.
+func NewWatcher() (*Watcher, error) {
+    fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF | unix.FAN_CLOEXEC | unix.FAN_NONBLOCK, unix.O_RDONLY | unix.O_LARGEFILE | unix.O_CLOEXEC)
+    if err != nil { return nil, err }
+
+    w := &Watcher{
+        Events: make(chan fsnotify.Event),
+        Errors: make(chan error),
+        fd: fd,
+        // a non blocking file uses the runtime poller, so Close interrupts the read
+        file: os.NewFile(uintptr(fd), "fanotify"),
+        self: os.Getpid(),
+        marks: make(map[string]uint),
+        roots: make(map[string]string),
+    }
+    go w.readEvents()
+    return w, nil
+}
+
+// Add watches a directory and the files directly inside it.
+func (w *Watcher) Add(name string) error {
+    err := unix.FanotifyMark(w.fd, unix.FAN_MARK_ADD, watchMask | unix.FAN_EVENT_ON_CHILD | unix.FAN_ONDIR, unix.AT_FDCWD, name)
+    if err != nil { return err }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.marks[name] = unix.FAN_MARK_INODE
+    return nil
+}
+
+// AddMount watches every file on the mount holding name, or on its whole
+// filesystem, with a single mark. Only the events below name are reported.
+func (w *Watcher) AddMount(name string, filesystem bool) error {
+    var kind uint = unix.FAN_MARK_MOUNT
+    if filesystem { kind = unix.FAN_MARK_FILESYSTEM }
+    root, err := filepath.EvalSymlinks(name)
+    if err != nil { return err }
+    err = unix.FanotifyMark(w.fd, unix.FAN_MARK_ADD | kind, watchMask | unix.FAN_ONDIR, unix.AT_FDCWD, name)
+    if err != nil { return err }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.marks[name] = kind
+    w.roots[name] = root
+    return nil
+}
+
+func (w *Watcher) Remove(name string) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    kind, ok := w.marks[name]
+    if ok == false {
+        return errors.New("can't remove non-existent fanotify watch for: " + name)
+    }
+    delete(w.marks, name)
+    delete(w.roots, name)
+    return unix.FanotifyMark(w.fd, unix.FAN_MARK_REMOVE | kind, watchMask | unix.FAN_EVENT_ON_CHILD | unix.FAN_ONDIR, unix.AT_FDCWD, name)
+}
+
+// WatchList returns the directories currently being watched. Unlike inotify,
+// fanotify marks don't follow a directory that is moved.
+func (w *Watcher) WatchList() []string {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    entries := make([]string, 0, len(w.marks))
+    for name := range w.marks {
+        entries = append(entries, name)
+    }
+    return entries
+}
+
+func (w *Watcher) Close() error {
+    return w.file.Close()
+}
+
+func (w *Watcher) readEvents() {
+    defer close(w.Events)
+    defer close(w.Errors)
+
+    var buf [4096 * 8]byte
+    metadataSize := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+    for {
+        n, err := w.file.Read(buf[:])
+        if err != nil {
+            // closing the file is how the watcher is stopped
+            if errors.Is(err, os.ErrClosed) { return }
+            w.Errors <- err
+            // running out of file descriptors only loses the event being read
+            if errors.Is(err, unix.EMFILE) || errors.Is(err, unix.ENFILE) { continue }
+            return
+        }
+
+        for offset := 0; offset + metadataSize <= n; {
+            meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[offset]))
+            if meta.Event_len < uint32(metadataSize) { break }
+            offset += int(meta.Event_len)
+
+            if meta.Vers != unix.FANOTIFY_METADATA_VERSION {
+                w.Errors <- errors.New("fanotify: unexpected metadata version " + strconv.Itoa(int(meta.Vers)))
+                continue
+            }
+            if meta.Mask & unix.FAN_Q_OVERFLOW == unix.FAN_Q_OVERFLOW {
+                w.Errors <- fsnotify.ErrEventOverflow
+                continue
+            }
+            if meta.Fd < 0 { continue }
+
+            name, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(meta.Fd)))
+            unix.Close(int(meta.Fd))
+            pid := int(meta.Pid)
+
+            // our own walks and reads would otherwise show up in the capture
+            if err != nil || pid == w.self { continue }
+            name = strings.TrimSuffix(name, " (deleted)")
+            if w.inRoots(name) == false { continue }
+
+            // look the process up straight away, before it has a chance to exit
+            procinfo.Lookup(pid)
+
+            // the kernel merges repeated events, split them back up like inotify reports them
+            now := time.Now()
+            for _, m := range opMasks {
+                if meta.Mask & m.mask != m.mask { continue }
+                w.Events <- fsnotify.Event{
+                    Name: name,
+                    Op: m.op,
+                    Time: now,
+                    IsDir: meta.Mask & unix.FAN_ONDIR == unix.FAN_ONDIR,
+                    Pid: pid,
+                }
+            }
+        }
+    }
+}
+
+// inRoots checks that a path is below one of the roots of the mount and
+// filesystem marks, when there are any.
+func (w *Watcher) inRoots(path string) bool {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if len(w.roots) == 0 { return true }
+    for _, root := range w.roots {
+        if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/") + "/") { return true }
+    }
+    return false
+}
+
+// opMasks is in the order the events happen to a file
+var opMasks = []struct {
+    mask uint64
+    op fsnotify.Op
+}{
+    {unix.FAN_OPEN, fsnotify.Open},
+    {unix.FAN_ACCESS, fsnotify.Access},
+    {unix.FAN_MODIFY, fsnotify.Write},
+    {unix.FAN_CLOSE_WRITE, fsnotify.CloseWrite},
+    {unix.FAN_CLOSE_NOWRITE, fsnotify.CloseNoWrite},
+}
@@ -0,0 +1,25 @@
+// +build !linux
+
+package fanotify
+
+import (
+    "errors"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// Watcher is only available on linux, NewWatcher always fails elsewhere.
+type Watcher struct {
+    Events chan fsnotify.Event
+    Errors chan error
+}
+
+func NewWatcher() (*Watcher, error) {
+    return nil, errors.New("fanotify is only supported on linux")
+}
+
+func (w *Watcher) Add(name string) error { return nil }
+func (w *Watcher) AddMount(name string, filesystem bool) error { return nil }
+func (w *Watcher) Remove(name string) error { return nil }
+func (w *Watcher) WatchList() []string { return nil }
+func (w *Watcher) Close() error { return nil }
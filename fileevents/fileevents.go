@@ -1,14 +1,129 @@
 package fileevents
 
 import (
+    "fmt"
     "strings"
+    "time"
     "github.com/fsnotify/fsnotify"
 )
 
+// Ops lists every operation that can be recorded, in summary column order.
+var Ops = []fsnotify.Op{
+    fsnotify.Create,
+    fsnotify.Write,
+    fsnotify.Remove,
+    fsnotify.Rename,
+    fsnotify.Chmod,
+    fsnotify.Open,
+    fsnotify.CloseWrite,
+    fsnotify.CloseNoWrite,
+    fsnotify.Access,
+}
+
+var opNames = map[fsnotify.Op]string {
+    fsnotify.Create: "Create",
+    fsnotify.Write: "Write",
+    fsnotify.Remove: "Remove",
+    fsnotify.Rename: "Rename",
+    fsnotify.Chmod: "Chmod",
+    fsnotify.Open: "Open",
+    fsnotify.CloseWrite: "CloseWrite",
+    fsnotify.CloseNoWrite: "CloseNoWrite",
+    fsnotify.Access: "Access",
+}
+
+// OpName returns the column name used for an operation.
+func OpName(op fsnotify.Op) string {
+    return opNames[op]
+}
+
+// OpNames lists the names of every operation set in a combined op.
+func OpNames(op fsnotify.Op) []string {
+    names := []string{}
+    for _, o := range Ops {
+        if op & o == o {
+            names = append(names, opNames[o])
+        }
+    }
+    return names
+}
+
+// OpByName is the reverse of OpName.
+func OpByName(name string) (fsnotify.Op, bool) {
+    for op, n := range opNames {
+        if n == name { return op, true }
+    }
+    return 0, false
+}
+
+// ParseOps turns a comma separated list of op names into a combined op.
+func ParseOps(list string) (fsnotify.Op, error) {
+    var op fsnotify.Op
+    for _, name := range strings.Split(list, ",") {
+        o, ok := OpByName(strings.TrimSpace(name))
+        if ok == false {
+            return 0, fmt.Errorf("unknown op %q", name)
+        }
+        op |= o
+    }
+    return op, nil
+}
+
+// AllOpsMask returns a record mask with every operation in Ops set.
+func AllOpsMask() uint {
+    var mask uint
+    for _, op := range Ops {
+        mask |= uint(op)
+    }
+    return mask
+}
+
 type FileWithEvents struct {
     Name string
     Events map[fsnotify.Op]int
     Total int
+    FirstSeen time.Time
+    LastSeen time.Time
+
+    // Processes counts events by the process behind them, as comm[pid], when
+    // the backend can tell.
+    Processes map[string]int
+}
+
+// Move is a file or directory moving from one path to another. When only one
+// half of the move was seen, the other side was outside the watched tree and
+// its path is empty.
+type Move struct {
+    From string
+    To string
+    Time time.Time
+    IsDir bool
+}
+
+// Op is the operation the move is counted as: moving into the tree looks like a
+// create, anything else like a rename.
+func (m Move) Op() fsnotify.Op {
+    if m.From == "" { return fsnotify.Create }
+    return fsnotify.Rename
+}
+
+// Path is the destination of the move, or the source if it left the tree.
+func (m Move) Path() string {
+    if m.To == "" { return m.From }
+    return m.To
+}
+
+// Kind names the move: "Move", or "MovedIn"/"MovedOut" when only one half was seen.
+func (m Move) Kind() string {
+    if m.From == "" { return "MovedIn" }
+    if m.To == "" { return "MovedOut" }
+    return "Move"
+}
+
+func (m Move) String() string {
+    if m.From == "" { return fmt.Sprintf("%q: MOVED_IN", m.To) }
+    if m.To == "" { return fmt.Sprintf("%q: MOVED_OUT", m.From) }
+    return fmt.Sprintf("%q -> %q: MOVE", m.From, m.To)
 }
 
 type ByEventTotal []FileWithEvents
@@ -7,12 +7,26 @@ import (
     "os/signal"
     "path/filepath"
     "bufio"
+    "io"
     "io/ioutil"
     "strings"
+    "sync"
+    "syscall"
+    "time"
 
     "github.com/fsnotify/fsnotify"
 
+    "github.com/AstromechZA/inotify-spy/diff"
     "github.com/AstromechZA/inotify-spy/eventbox"
+    "github.com/AstromechZA/inotify-spy/backend"
+    "github.com/AstromechZA/inotify-spy/exechook"
+    "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/live"
+    "github.com/AstromechZA/inotify-spy/metrics"
+    "github.com/AstromechZA/inotify-spy/moves"
+    "github.com/AstromechZA/inotify-spy/preflight"
+    "github.com/AstromechZA/inotify-spy/server"
+    "github.com/AstromechZA/inotify-spy/session"
     "github.com/AstromechZA/inotify-spy/summary"
 )
 
@@ -39,20 +53,59 @@ It will allow you to watch a directory or directory tree for file events:
 - Rename
 - Chmod
 - Open
+- CloseWrite (closed after being written to)
+- CloseNoWrite (closed without being written to)
+- Access (read)
 
 Because this tool uses inotify events, it has to create a inotify file for each
 directory you want to watch. On most systems there is a limit to the number of
 inotify files a process is allowed to create at once. You might hit these
 limits if you try to watch a very large tree of directories. On most systems
-there are ways to increase these limits if required.
+there are ways to increase these limits if required: before watching, a
+preflight check compares the limits with the directories to watch and prints
+the sysctl to run when they are too low. On linux as root,
+-backend fanotify with -fanotify-scope mount or filesystem avoids the limits by
+watching the whole mount with a single mark.
 
 This tool will continue to watch the tree until you stop it using SIGINT (Ctrl-C)
-which will cause it to print a sorted summary of the files touched.
+or SIGTERM which will cause it to print a sorted summary of the files touched.
+While recording, SIGUSR1 prints (and exports) a snapshot of the summary so
+far and SIGUSR2 resets the counts, without stopping the capture. SIGHUP reloads
+the -ignore-prefixes file and adds or removes watches to match it.
 
 Usage: inotify-spy [-live] [-mute-errors] [-recursive] directory
 
+To record what a command touches, give it after -- and the capture will run
+for as long as the command does, exiting with the command's exit code:
+
+Usage: inotify-spy [options] directory -- command [args...]
+
+A session file recorded with -record can be summarised again later, with any
+of the summary, -dont-record-* and -ignore-prefixes options applied afresh:
+
+Usage: inotify-spy report [options] session-file
+
+Two captures, each either a session file or a csv export, can be compared to
+show which paths were added, removed or changed between them:
+
+Usage: inotify-spy diff [options] before after
+
 `
 
+// commandSettle is how long to keep watching after a wrapped command exits
+const commandSettle = 100 * time.Millisecond
+
+// ownReadWindow is how long after inotify-spy reads a directory itself that
+// open, read and close events on it are taken to be its own
+const ownReadWindow = 100 * time.Millisecond
+
+// execStopGrace is how long running -exec commands get to exit once asked to
+const execStopGrace = 3 * time.Second
+
+// status is where progress and error messages go. It is moved to stderr when
+// stdout carries a machine readable live stream.
+var status io.Writer = os.Stdout
+
 func safeAbsolutePath(path string) string {
     abspath, err := filepath.Abs(path)
     if err == nil { return abspath }
@@ -68,42 +121,173 @@ func mustIgnorePath(path string, ignore *[]string) bool {
     return false
 }
 
-func addDirWatchers(w *fsnotify.Watcher, wyes *int, wno *int, mute bool, ignorePrefixes *[]string) filepath.WalkFunc {
+// resolvePrefixes resolves the symlinks in ignore prefixes, to match paths
+// that are reported that way. Prefixes that don't resolve, such as partial
+// names, are kept as they are.
+func resolvePrefixes(prefixes []string) []string {
+    resolved := make([]string, 0, len(prefixes))
+    for _, prefix := range prefixes {
+        real, err := filepath.EvalSymlinks(prefix)
+        if err != nil {
+            resolved = append(resolved, prefix)
+            continue
+        }
+        // a trailing slash only matches inside the directory
+        if strings.HasSuffix(prefix, "/") && strings.HasSuffix(real, "/") == false {
+            real += "/"
+        }
+        resolved = append(resolved, real)
+    }
+    return resolved
+}
+
+// watchCounts counts the directories that are and aren't watched. The event
+// goroutine, main and the http server all use it, so it goes through a lock.
+type watchCounts struct {
+    mu sync.Mutex
+    watched int
+    notWatched int
+}
+
+func (c *watchCounts) add(watched int, notWatched int) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.watched += watched
+    c.notWatched += notWatched
+}
+
+func (c *watchCounts) set(watched int, notWatched int) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.watched, c.notWatched = watched, notWatched
+}
+
+func (c *watchCounts) get() (int, int) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.watched, c.notWatched
+}
+
+// addDirWatchers watches each directory it walks past. When found isn't nil it
+// is also given every path that isn't ignored, files included.
+func addDirWatchers(w backend.Backend, counts *watchCounts, mute bool, ignorePrefixes *[]string, found func(string, os.FileInfo)) filepath.WalkFunc {
     return func(path string, info os.FileInfo, err error) error {
         if err != nil { return nil }
-        if info.IsDir() {
-            path = safeAbsolutePath(path)
+        path = safeAbsolutePath(path)
 
-            if mustIgnorePath(path, ignorePrefixes) {
-                fmt.Printf("Not watching %v or its children since it matches an ignore prefix\n", path)
-                return filepath.SkipDir
+        if info.IsDir() == false {
+            if found != nil && mustIgnorePath(path, ignorePrefixes) == false {
+                found(path, info)
             }
+            return nil
+        }
 
-            e := w.Add(path)
-            if e != nil {
-                if mute == false {
-                    fmt.Printf("Failed to watch %v: %v\n", path, e.Error())
-                }
-                (*wno)++
-                return nil
+        if mustIgnorePath(path, ignorePrefixes) {
+            fmt.Fprintf(status, "Not watching %v or its children since it matches an ignore prefix\n", path)
+            return filepath.SkipDir
+        }
+
+        e := w.Add(path)
+        if e != nil {
+            if mute == false {
+                fmt.Fprintf(status, "Failed to watch %v: %v\n", path, e.Error())
             }
-            (*wyes)++
+            counts.add(0, 1)
+            return nil
         }
+        counts.add(1, 0)
+        if found != nil { found(path, info) }
         return nil
     }
 }
 
+// unignoredParents lists the watched directories holding the paths that the
+// old prefixes ignored and the new ones may not, which are all a reload needs
+// to look at.
+func unignoredParents(root string, oldPrefixes []string, newPrefixes []string) []string {
+    kept := make(map[string]bool)
+    for _, prefix := range newPrefixes { kept[prefix] = true }
+
+    var parents []string
+    seen := make(map[string]bool)
+    for _, prefix := range oldPrefixes {
+        if kept[prefix] { continue }
+        // a prefix ending in a slash covers what is inside the directory
+        parent := filepath.Dir(prefix)
+        if seen[parent] { continue }
+        seen[parent] = true
+
+        if parent != root && strings.HasPrefix(parent, strings.TrimSuffix(root, "/") + "/") == false { continue }
+        // a parent that was itself ignored is walked from further up
+        if mustIgnorePath(parent, &oldPrefixes) || mustIgnorePath(parent, &newPrefixes) { continue }
+        parents = append(parents, parent)
+    }
+    return parents
+}
+
+// readDirs notes every directory a walk reads. filepath.Walk lists a directory
+// before walkFn sees it, so ignored directories are read as well.
+func readDirs(walked *[]string, walkFn filepath.WalkFunc) filepath.WalkFunc {
+    return func(path string, info os.FileInfo, err error) error {
+        if err == nil && info.IsDir() {
+            *walked = append(*walked, safeAbsolutePath(path))
+        }
+        return walkFn(path, info, err)
+    }
+}
+
+func loadIgnorePrefixes(path string) ([]string, error) {
+    fmt.Fprintf(status, "Loading ignore prefixes from %v\n", path)
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        fmt.Fprintf(status, "Could not open ignore prefixes file %v: %v\n", path, err.Error())
+        return nil, err
+    }
+    prefixes := strings.Split(strings.TrimSpace(string(content)), "\n")
+    fmt.Fprintf(status, "Loaded %d ignore prefixes\n", len(prefixes))
+    return prefixes, nil
+}
+
+// countDirectories counts the directories addDirWatchers would watch.
+func countDirectories(root string, recursive bool, ignorePrefixes *[]string) int {
+    if recursive == false { return 1 }
+    count := 0
+    filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+        if err != nil || info.IsDir() == false { return nil }
+        if mustIgnorePath(safeAbsolutePath(path), ignorePrefixes) { return filepath.SkipDir }
+        count++
+        return nil
+    })
+    return count
+}
+
 func main() {
 
     // flag args
     recursiveFlag := flag.Bool("recursive", false, "Recursively watch target directory")
     liveFlag := flag.Bool("live", false, "Show events live, not just as a summary at the end")
+    liveTemplateFlag := flag.String("live-template", "", "Go text/template for each live event, with .Time .Path .RelPath .Dir .Base .Ext .Ops .IsDir, and .Pid .Comm .Exe with -backend fanotify (implies -live)")
+    liveCoalesceFlag := flag.Duration("live-coalesce", 0, "Merge live events for the same path that arrive within this window of each other, such as 50ms")
+    liveFormatFlag := flag.String("live-format", "text", "Format of live events: text, or json for one object per line on stdout with status messages on stderr (implies -live)")
+    backendFlag := flag.String("backend", "inotify", "How to watch for events: inotify, fanotify to also see which process caused each event (linux only, needs CAP_SYS_ADMIN, falls back to inotify), or polling to scan for changes inotify can't see, such as on NFS or FUSE")
+    pollIntervalFlag := flag.Duration("poll-interval", 2 * time.Second, "How often -backend polling scans the watched directories")
+    pollMaxFilesFlag := flag.Int("poll-max-files", 100000, "Most files -backend polling looks at in one scan, 0 for no limit")
+    fanotifyScopeFlag := flag.String("fanotify-scope", "directory", "What -backend fanotify marks: directory for each watched directory, or mount or filesystem to watch the whole tree below the target with a single mark")
+    strictFlag := flag.Bool("strict", false, "Exit before watching anything if the inotify limits are too low to watch every directory")
     muteErrorsFlag := flag.Bool("mute-errors", false, "Mute error messages related to setting up watches")
     versionFlag := flag.Bool("version", false, "Print version information")
 
+    // capture length
+    noWaitFlag := flag.Bool("no-wait", false, "Start recording as soon as the watches are placed instead of waiting for enter")
+    durationFlag := flag.Duration("duration", 0, "Stop and print the summary after recording for this long, such as 30s")
+    maxEventsFlag := flag.Int("max-events", 0, "Stop and print the summary after recording this many events")
+
     // summary flags
     sortByNameFlag := flag.Bool("sort-name", false, "Sort summary by file path rather than most events")
     exportCSVFlag := flag.String("export-csv", "", "Export summary as csv to the given path")
+    exportJSONFlag := flag.String("export-json", "", "Export summary (or diff) as json to the given path")
+    exportNDJSONFlag := flag.String("export-ndjson", "", "Export summary as newline delimited json to the given path")
+    showTimesFlag := flag.Bool("show-times", false, "Include first-seen and last-seen times in the summary")
 
     // record options
     dontRecordCreate := flag.Bool("dont-record-create", false, "Don't record create events")
@@ -112,6 +296,24 @@ func main() {
     dontRecordRename := flag.Bool("dont-record-rename", false, "Don't record rename events")
     dontRecordChmod := flag.Bool("dont-record-chmod", false, "Don't record chmod events")
     dontRecordOpen := flag.Bool("dont-record-open", false, "Don't record open events")
+    dontRecordCloseWrite := flag.Bool("dont-record-close-write", false, "Don't record close-after-write events")
+    dontRecordCloseNoWrite := flag.Bool("dont-record-close-nowrite", false, "Don't record close-without-write events")
+    dontRecordAccess := flag.Bool("dont-record-access", false, "Don't record access (read) events")
+
+    // exec hook
+    execFlag := flag.String("exec", "", "Command to run with sh -c for matching events, given the path and ops as $1 and $2, and as INOTIFY_SPY_PATH and INOTIFY_SPY_OPS")
+    execOpsFlag := flag.String("exec-ops", "", "Comma separated ops that trigger -exec, such as Create,Write (default all recorded ops)")
+    execPatternFlag := flag.String("exec-pattern", "", "Comma separated globs that trigger -exec, matched against the full path or the file name (default any path)")
+    execDebounceFlag := flag.Duration("exec-debounce", 200 * time.Millisecond, "How long a path must be quiet before -exec runs for it")
+    execConcurrencyFlag := flag.Int("exec-concurrency", 1, "Maximum number of -exec commands running at once")
+    execModeFlag := flag.String("exec-mode", exechook.ModeQueue, "What to do when -exec-concurrency is reached: queue, drop, or restart the oldest running command")
+
+    // http server
+    httpFlag := flag.String("http", "", "Serve the capture in progress over http on the given address, such as :8080")
+    metricsPrefixesFlag := flag.String("metrics-prefixes", "", "Comma separated path prefixes to group event counts by in the -http /metrics endpoint")
+
+    // session recording
+    recordFileFlag := flag.String("record", "", "Append every recorded event to the given session file")
 
     // ignore prefixes
     ignorePrefixFlag := flag.String("ignore-prefixes", "", "File to read ignore prefixes from")
@@ -121,138 +323,731 @@ func main() {
         flag.PrintDefaults()
     }
 
-    // parse them
-    flag.Parse()
+    // parse them, the report and diff modes are selected by a leading argument
+    mode := ""
+    if len(os.Args) > 1 && (os.Args[1] == "report" || os.Args[1] == "diff") {
+        mode = os.Args[1]
+        flag.CommandLine.Parse(os.Args[2:])
+    } else {
+        flag.Parse()
+    }
 
     if (*versionFlag) {
         fmt.Print(versionString)
         os.Exit(0)
     }
 
-    // make sure we have our single positional arg
-    if len(flag.Args()) != 1 {
+    // anything after -- is a command to record for the lifetime of
+    args := flag.Args()
+    var command []string
+    if mode == "" {
+        for i, arg := range args {
+            if arg == "--" {
+                args, command = args[:i], args[i + 1:]
+                if len(command) == 0 {
+                    flag.Usage()
+                    os.Exit(1)
+                }
+                break
+            }
+        }
+    }
+    if command != nil && (*durationFlag > 0 || *maxEventsFlag > 0) {
+        fmt.Fprintln(os.Stderr, "-duration and -max-events can't be combined with a command, the capture stops when the command exits")
+        os.Exit(1)
+    }
+
+    // make sure we have our single positional arg, or two to diff
+    expectedArgs := 1
+    if mode == "diff" { expectedArgs = 2 }
+    if len(args) != expectedArgs {
         flag.Usage()
         os.Exit(1)
     }
 
-    targetDir := flag.Args()[0]
+    // in report mode this is the session file instead, and in diff mode the first capture
+    targetDir := args[0]
+
+    // pick the live printer, json keeps stdout clean for other tools
+    var printer live.Printer
+    switch *liveFormatFlag {
+    case "text":
+        if *liveFlag {
+            printer = live.NewTextPrinter(os.Stdout)
+        }
+    case "json":
+        status = os.Stderr
+        printer = live.NewJSONPrinter(os.Stdout, safeAbsolutePath(targetDir))
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown live format %v, expected text or json\n", *liveFormatFlag)
+        os.Exit(1)
+    }
+    if *liveTemplateFlag != "" {
+        if *liveFormatFlag != "text" {
+            fmt.Fprintln(os.Stderr, "-live-template can't be combined with -live-format")
+            os.Exit(1)
+        }
+        tmpl, err := live.ParseTemplate(*liveTemplateFlag)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Could not parse live template: %v\n", err.Error())
+            os.Exit(1)
+        }
+        status = os.Stderr
+        printer = live.NewTemplatePrinter(os.Stdout, tmpl, safeAbsolutePath(targetDir))
+    }
+    // only the live output is coalesced, the summary still sees every event
+    var coalescer *live.Coalescer
+    if printer != nil && *liveCoalesceFlag > 0 {
+        coalescer = live.NewCoalescer(printer, *liveCoalesceFlag)
+        printer = coalescer
+    }
 
     // read ignore prefixes if required
     var ignorePrefixes []string
     ignorePrefixFile := *ignorePrefixFlag
     if ignorePrefixFile != "" {
-        fmt.Printf("Loading ignore prefixes from %v\n", ignorePrefixFile)
-        prefixes, err := ioutil.ReadFile(ignorePrefixFile)
+        prefixes, err := loadIgnorePrefixes(ignorePrefixFile)
+        if err != nil { os.Exit(1) }
+        ignorePrefixes = prefixes
+    }
+
+    box := eventbox.NewEventBox()
+
+    recordMask := fileevents.AllOpsMask()
+    if (*dontRecordCreate) == true { recordMask -= uint(fsnotify.Create) }
+    if (*dontRecordWrite) == true { recordMask -= uint(fsnotify.Write) }
+    if (*dontRecordRemove) == true { recordMask -= uint(fsnotify.Remove) }
+    if (*dontRecordRename) == true { recordMask -= uint(fsnotify.Rename) }
+    if (*dontRecordChmod) == true { recordMask -= uint(fsnotify.Chmod) }
+    if (*dontRecordOpen) == true { recordMask -= uint(fsnotify.Open) }
+    if (*dontRecordCloseWrite) == true { recordMask -= uint(fsnotify.CloseWrite) }
+    if (*dontRecordCloseNoWrite) == true { recordMask -= uint(fsnotify.CloseNoWrite) }
+    if (*dontRecordAccess) == true { recordMask -= uint(fsnotify.Access) }
+
+
+    summaryOptions := summary.Options{
+        Output: status,
+        SortByName: *sortByNameFlag,
+        ShowTimes: *showTimesFlag,
+        ExportCSV: *exportCSVFlag,
+        ExportJSON: *exportJSONFlag,
+        ExportNDJSON: *exportNDJSONFlag,
+    }
+
+    if mode == "diff" {
+        beforePath, afterPath := args[0], args[1]
+        before, beforeOps, err := loadCapture(beforePath, recordMask, &ignorePrefixes)
+        if err != nil {
+            fmt.Fprintf(status, "Could not load %v: %v\n", beforePath, err.Error())
+            os.Exit(1)
+        }
+        after, afterOps, err := loadCapture(afterPath, recordMask, &ignorePrefixes)
+        if err != nil {
+            fmt.Fprintf(status, "Could not load %v: %v\n", afterPath, err.Error())
+            os.Exit(1)
+        }
+
+        // an op missing from either capture would show up as a false delta
+        compareMask := beforeOps & afterOps
+        if skipped := fsnotify.Op(recordMask &^ compareMask); skipped != 0 {
+            fmt.Fprintf(status, "Not comparing %s: not recorded in both captures\n", strings.Join(fileevents.OpNames(skipped), ", "))
+        }
+
+        err = diff.DoDiff(beforePath, before, afterPath, after, compareMask, *sortByNameFlag, *exportCSVFlag, *exportJSONFlag)
+        if err != nil {
+            fmt.Fprintf(status, "Error: %s\n", err.Error())
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+
+    if mode == "report" {
+        info, err := replaySession(targetDir, box, recordMask, &ignorePrefixes)
+        if err != nil {
+            fmt.Fprintf(status, "Could not replay session %v: %v\n", targetDir, err.Error())
+            os.Exit(1)
+        }
+
+        capture := summary.Capture{Target: info.Target, Recursive: info.Recursive, RecordMask: info.RecordMask & recordMask}
+        capture.Start, capture.Stop = box.TimeRange()
+        err = summary.DoSummary(box, capture, summaryOptions)
         if err != nil {
-            fmt.Printf("Could not open ignore prefixes file %v: %v\n", ignorePrefixFile, err.Error())
+            fmt.Fprintf(status, "Error: %s\n", err.Error())
             os.Exit(1)
         }
-        ignorePrefixes = strings.Split(strings.TrimSpace(string(prefixes)), "\n")
-        fmt.Printf("Loaded %d ignore prefixes\n", len(ignorePrefixes))
+        os.Exit(0)
     }
 
     // setup watcher
-    watcher, err := fsnotify.NewWatcher()
+    if backend.Exists(*backendFlag) == false {
+        fmt.Fprintf(status, "Unknown backend %v, expected one of %v\n", *backendFlag, strings.Join(backend.Names(), ", "))
+        os.Exit(1)
+    }
+    // a mount or filesystem mark covers the whole tree, so no directories are walked
+    wholeMount := false
+    switch *fanotifyScopeFlag {
+    case "directory":
+    case "mount", "filesystem":
+        if *backendFlag != "fanotify" {
+            fmt.Fprintln(status, "-fanotify-scope mount and filesystem need -backend fanotify")
+            os.Exit(1)
+        }
+        wholeMount = true
+    default:
+        fmt.Fprintf(status, "Unknown fanotify scope %v, expected directory, mount or filesystem\n", *fanotifyScopeFlag)
+        os.Exit(1)
+    }
+    if wholeMount {
+        // the events of a mount mark come with their symlinks resolved
+        ignorePrefixes = resolvePrefixes(ignorePrefixes)
+    }
+    // check the inotify limits up front, before our own instance is taken or watches start failing
+    if *backendFlag == "inotify" {
+        report, err := preflight.Check(countDirectories(targetDir, *recursiveFlag, &ignorePrefixes))
+        passed := false
+        if err != nil {
+            fmt.Fprintf(status, "Skipping the inotify limits check: %v\n", err.Error())
+        } else {
+            report.Print(status)
+            passed = report.OK()
+        }
+        if *strictFlag && passed == false {
+            fmt.Fprintln(status, "Not starting since -strict was given.")
+            os.Exit(1)
+        }
+    }
+    watcher, err := openBackend(*backendFlag, backend.Options{
+        PollInterval: *pollIntervalFlag,
+        PollMaxFiles: *pollMaxFilesFlag,
+    })
     if err != nil {
-        fmt.Printf("Failed to setup fsnotify watcher: %v\n", err.Error())
-        fmt.Println("The fsnotify watcher may not support your operating system or kernel version.")
+        fmt.Fprintf(status, "Failed to setup fsnotify watcher: %v\n", err.Error())
+        fmt.Fprintln(status, "The fsnotify watcher may not support your operating system or kernel version.")
         os.Exit(1)
     }
     // make sure we close it
     defer watcher.Close()
 
+    caps := watcher.Capabilities()
+    var unsupported []string
+    for _, op := range summary.RecordedOps(recordMask) {
+        if caps.Supports(op) == false {
+            unsupported = append(unsupported, fileevents.OpName(op))
+        }
+    }
+    if len(unsupported) > 0 {
+        fmt.Fprintf(status, "This watcher can't see %v events, so they won't be recorded.\n", strings.Join(unsupported, ", "))
+    }
+    // columns for ops that can't be seen would only ever hold zeroes
+    recordMask &= uint(caps.Ops)
+
     // get mute value
     mustMute := *muteErrorsFlag
 
-    var watchedCounter int
-    var notWatchedCounter int
-    box := eventbox.NewEventBox()
+    var counts watchCounts
 
-    var recordMask uint = 63
-    if (*dontRecordCreate) == true { recordMask -= uint(fsnotify.Create) }
-    if (*dontRecordWrite) == true { recordMask -= uint(fsnotify.Write) }
-    if (*dontRecordRemove) == true { recordMask -= uint(fsnotify.Remove) }
-    if (*dontRecordRename) == true { recordMask -= uint(fsnotify.Rename) }
-    if (*dontRecordChmod) == true { recordMask -= uint(fsnotify.Chmod) }
-    if (*dontRecordOpen) == true { recordMask -= uint(fsnotify.Open) }
+    var recorder *session.Writer
+    if *recordFileFlag != "" {
+        recorder, err = session.NewWriter(*recordFileFlag, session.Info{
+            Target: safeAbsolutePath(targetDir),
+            Recursive: *recursiveFlag,
+            RecordMask: recordMask,
+        })
+        if err != nil {
+            fmt.Fprintf(status, "Could not open session file %v: %v\n", *recordFileFlag, err.Error())
+            os.Exit(1)
+        }
+    }
+
+    // the start moves on a reset while the http server may be reading it
+    var startLock sync.Mutex
+    var startTime time.Time
+    setStartTime := func(t time.Time) {
+        startLock.Lock()
+        defer startLock.Unlock()
+        startTime = t
+    }
+
+    // currentCapture describes the capture from the start, or the last reset, up to stop
+    currentCapture := func(stop time.Time) summary.Capture {
+        startLock.Lock()
+        start := startTime
+        startLock.Unlock()
+        watched, notWatched := counts.get()
+        return summary.Capture{
+            Target: safeAbsolutePath(targetDir),
+            Recursive: *recursiveFlag,
+            RecordMask: recordMask,
+            Start: start,
+            Stop: stop,
+            Watched: watched,
+            NotWatched: notWatched,
+        }
+    }
+
+    var httpServer *server.Server
+    var promMetrics *metrics.Metrics
+    if *httpFlag != "" {
+        httpServer = server.New(box, func() summary.Capture { return currentCapture(time.Time{}) })
+
+        var groupPrefixes []string
+        if *metricsPrefixesFlag != "" {
+            groupPrefixes = strings.Split(*metricsPrefixesFlag, ",")
+        }
+        promMetrics = metrics.New(groupPrefixes, counts.get)
+        httpServer.Handle("/metrics", promMetrics)
+
+        err = httpServer.Listen(*httpFlag)
+        if err != nil {
+            fmt.Fprintf(status, "Could not listen on %v: %v\n", *httpFlag, err.Error())
+            os.Exit(1)
+        }
+        fmt.Fprintf(status, "Serving the capture on http://%v/\n", *httpFlag)
+
+        // the server streams recorded events alongside any live output
+        if printer != nil {
+            printer = live.Tee{printer, httpServer}
+        } else {
+            printer = httpServer
+        }
+    }
+
+    var runner *exechook.Runner
+    if *execFlag != "" {
+        execConfig := exechook.Config{
+            Command: *execFlag,
+            Ops: fsnotify.Op(recordMask),
+            Debounce: *execDebounceFlag,
+            Concurrency: *execConcurrencyFlag,
+            Mode: *execModeFlag,
+        }
+        if *execOpsFlag != "" {
+            execConfig.Ops, err = fileevents.ParseOps(*execOpsFlag)
+            if err != nil {
+                fmt.Fprintf(status, "Bad -exec-ops: %v\n", err.Error())
+                os.Exit(1)
+            }
+        }
+        if *execPatternFlag != "" {
+            execConfig.Patterns = strings.Split(*execPatternFlag, ",")
+        }
+        runner, err = exechook.NewRunner(execConfig, status)
+        if err != nil {
+            fmt.Fprintf(status, "Could not set up -exec: %v\n", err.Error())
+            os.Exit(1)
+        }
+    }
 
-    fmt.Println("Beginning to watch events..")
-    readyChannel := make(chan bool)
+    fmt.Fprintln(status, "Beginning to watch events..")
+    // readyChannel starts the recording, handing over the directories main has read while watching
+    readyChannel := make(chan []string)
     stopChannel := make(chan bool)
-    go func(live bool, box *eventbox.EventBox) {
+    stoppedChannel := make(chan bool)
+    reloadChannel := make(chan []string)
+    limitChannel := make(chan bool, 1)
+    go func(printer live.Printer, recursive bool, box *eventbox.EventBox) {
         ready := false
+        recorded := 0
+        pairer := moves.NewPairer(moves.DefaultWindow)
+        expireTicker := time.NewTicker(moves.DefaultWindow)
+        defer expireTicker.Stop()
+        defer close(stoppedChannel)
+
+        // dropEvent notes why a seen event is not being recorded
+        dropEvent := func() {
+            if promMetrics == nil { return }
+            if ready {
+                promMetrics.Dropped(metrics.DroppedMasked)
+            } else {
+                promMetrics.Dropped(metrics.DroppedNotRecording)
+            }
+        }
+
+        // countRecorded stops recording once -max-events is reached
+        countRecorded := func() {
+            recorded++
+            if *maxEventsFlag > 0 && recorded >= *maxEventsFlag {
+                ready = false
+                limitChannel <- true
+            }
+        }
+
+        recordEvent := func(e *fsnotify.Event) {
+            if ready == false || recordMask & uint(e.Op) != uint(e.Op) {
+                dropEvent()
+                return
+            }
+            if printer != nil {
+                printer.Event(e, 1)
+            }
+            if recorder != nil {
+                recorder.WriteEvent(e)
+            }
+            if runner != nil {
+                runner.Event(e.Name, e.Op)
+            }
+            if promMetrics != nil {
+                promMetrics.Event(e.Name, e.Op)
+            }
+            box.Add(e)
+            countRecorded()
+        }
+
+        recordMove := func(m fileevents.Move) {
+            if ready == false || recordMask & uint(m.Op()) != uint(m.Op()) {
+                dropEvent()
+                return
+            }
+            if printer != nil {
+                printer.Move(m)
+            }
+            if runner != nil {
+                runner.Event(m.Path(), m.Op())
+            }
+            if promMetrics != nil {
+                promMetrics.Event(m.Path(), m.Op())
+            }
+            box.AddMove(m)
+            countRecorded()
+        }
+
+        // ownReads holds the directories inotify-spy has just read itself, with
+        // the time until which their open, read and close events are its own
+        ownReads := make(map[string]time.Time)
+        isOwnRead := func(e *fsnotify.Event) bool {
+            if e.IsDir == false || e.Op & (fsnotify.Open | fsnotify.Access | fsnotify.CloseNoWrite) == 0 { return false }
+            until, ok := ownReads[e.Name]
+            return ok && e.Time.Before(until)
+        }
+
+        // watchTree watches a directory and everything in it, ignoring the
+        // reads of its own walk. With backfill it also creates the events that
+        // were missed before the watches were placed.
+        watchTree := func(root string, backfill bool) {
+            var missed []fsnotify.Event
+            var walked []string
+            filepath.Walk(root, readDirs(&walked, addDirWatchers(watcher, &counts, mustMute, &ignorePrefixes, func(path string, info os.FileInfo) {
+                // the root directory already has its own create event
+                if backfill && ready && path != root {
+                    missed = append(missed, fsnotify.Event{Name: path, Op: fsnotify.Create, Time: time.Now(), IsDir: info.IsDir()})
+                }
+            })))
+            // the kernel hands back the events from the walk shortly after it
+            until := time.Now().Add(ownReadWindow)
+            for _, dir := range walked {
+                ownReads[dir] = until
+            }
+            for i := range missed {
+                recordEvent(&missed[i])
+            }
+        }
+
+        // a backend that fails closes its channels, which are then left alone
+        // rather than read from in a loop
+        events, errs := watcher.Events(), watcher.Errors()
         for {
             select {
-            case event := <- watcher.Events:
-                if ready {
-                    if recordMask & uint(event.Op) == uint(event.Op) {
-                        event.Name = safeAbsolutePath(event.Name)
-                        if live {
-                            fmt.Printf("event: %v\n", event.String())
-                        }
-                        box.Add(&event)
+            case event, ok := <- events:
+                if ok == false {
+                    fmt.Fprintln(status, "The watcher stopped, no further events will be recorded.")
+                    events = nil
+                    continue
+                }
+                event.Name = safeAbsolutePath(event.Name)
+                // nothing is left unwatched when the whole mount is marked, so filter here instead
+                if wholeMount && mustIgnorePath(event.Name, &ignorePrefixes) { continue }
+                if event.Time.IsZero() {
+                    // not every platform timestamps its events
+                    event.Time = time.Now()
+                }
+                if isOwnRead(&event) { continue }
+                newInTree := event.Op & fsnotify.Create == fsnotify.Create
+                if event.Cookie != 0 {
+                    // both halves are kept in the session so it can be paired up again on replay
+                    if ready && recorder != nil {
+                        recorder.WriteEvent(&event)
+                    }
+
+                    // one half of a move, wait for the other half
+                    m, complete := pairer.Add(&event, event.Time)
+                    if complete {
+                        recordMove(m)
                     }
+                    // paired directory moves keep their existing watches
+                    newInTree = complete && m.From == ""
+                } else {
+                    recordEvent(&event)
+                }
+
+                // in recursive mode, new directories need their own watches
+                if recursive && newInTree {
+                    info, err := os.Lstat(event.Name)
+                    if err == nil && info.IsDir() {
+                        watchTree(event.Name, true)
+                    }
+                }
+            case now := <- expireTicker.C:
+                for _, m := range pairer.Expire(now) {
+                    recordMove(m)
                 }
-                // otherwise ignore it
-            case <- readyChannel:
+                for dir, until := range ownReads {
+                    if now.After(until) { delete(ownReads, dir) }
+                }
+            case walked := <- readyChannel:
                 ready = true
+                // the events from main's walk can still be on their way
+                until := time.Now().Add(ownReadWindow)
+                for _, dir := range walked {
+                    ownReads[dir] = until
+                }
+            case prefixes := <- reloadChannel:
+                oldPrefixes := ignorePrefixes
+                ignorePrefixes = prefixes
+                if wholeMount { ignorePrefixes = resolvePrefixes(prefixes) }
+                if recursive == false || wholeMount { continue }
+
+                // drop the watches that are now ignored
+                for _, path := range watcher.WatchList() {
+                    if mustIgnorePath(path, &ignorePrefixes) && watcher.Remove(path) == nil {
+                        counts.add(-1, 0)
+                    }
+                }
+                // then watch what the old prefixes hid, without walking the rest of the tree again
+                for _, parent := range unignoredParents(safeAbsolutePath(targetDir), oldPrefixes, ignorePrefixes) {
+                    entries, err := ioutil.ReadDir(parent)
+                    if err != nil { continue }
+                    ownReads[parent] = time.Now().Add(ownReadWindow)
+                    for _, entry := range entries {
+                        path := filepath.Join(parent, entry.Name())
+                        if entry.IsDir() && mustIgnorePath(path, &oldPrefixes) && mustIgnorePath(path, &ignorePrefixes) == false {
+                            watchTree(path, false)
+                        }
+                    }
+                }
+                watched, _ := counts.get()
+                fmt.Fprintf(status, "Watching %d directories..\n", watched)
             case <- stopChannel:
+                for _, m := range pairer.Flush() {
+                    recordMove(m)
+                }
+                if coalescer != nil {
+                    coalescer.Flush()
+                }
                 return
-            case err := <- watcher.Errors:
-                fmt.Printf("error: %v\n", err)
+            case err, ok := <- errs:
+                if ok == false {
+                    errs = nil
+                    continue
+                }
+                if err == fsnotify.ErrEventOverflow {
+                    if ready {
+                        now := time.Now()
+                        if printer != nil {
+                            printer.Overflow(now)
+                        }
+                        if recorder != nil {
+                            recorder.WriteOverflow(now)
+                        }
+                        box.AddOverflow()
+                    }
+                    if promMetrics != nil {
+                        promMetrics.Overflow()
+                    }
+                    continue
+                }
+                if promMetrics != nil {
+                    promMetrics.Error()
+                }
+                fmt.Fprintf(status, "error: %v\n", err)
             }
         }
-    }(*liveFlag, box)
+    }(printer, *recursiveFlag, box)
 
-    if (*recursiveFlag) {
-        err = filepath.Walk(targetDir, addDirWatchers(watcher, &watchedCounter, &notWatchedCounter, mustMute, &ignorePrefixes))
+    var walked []string
+    if wholeMount {
+        fan, ok := watcher.(backend.MountWatcher)
+        if ok == false {
+            fmt.Fprintf(status, "-fanotify-scope %v can't be used without fanotify\n", *fanotifyScopeFlag)
+            os.Exit(1)
+        }
+        err = fan.AddMount(safeAbsolutePath(targetDir), *fanotifyScopeFlag == "filesystem")
         if err != nil {
-            fmt.Printf("Could not walk %v: %v\n", targetDir, err.Error())
+            fmt.Fprintf(status, "Could not watch the %v holding %v: %v\n", *fanotifyScopeFlag, targetDir, err.Error())
             os.Exit(1)
         }
+        counts.add(1, 0)
+    } else if (*recursiveFlag) {
+        err = filepath.Walk(targetDir, readDirs(&walked, addDirWatchers(watcher, &counts, mustMute, &ignorePrefixes, nil)))
+        if err != nil {
+            fmt.Fprintf(status, "Could not walk %v: %v\n", targetDir, err.Error())
+            os.Exit(1)
+        }
+        // directories created during the walk can be watched by the event goroutine as well, count each once
+        _, notWatched := counts.get()
+        counts.set(len(watcher.WatchList()), notWatched)
     } else {
         err = watcher.Add(targetDir)
         if err != nil {
-            fmt.Printf("Could not watch %v: %v\n", targetDir, err.Error())
+            fmt.Fprintf(status, "Could not watch %v: %v\n", targetDir, err.Error())
             os.Exit(1)
         } else {
-            watchedCounter++
+            counts.add(1, 0)
         }
     }
 
-    fmt.Printf("Watching %d directories..\n", watchedCounter)
-    if notWatchedCounter > 0 {
-        fmt.Printf("Could not watch %d directories.\n", notWatchedCounter)
-        fmt.Println("If you got 'permission denied errors', try running as root.")
-        fmt.Println("If you got 'too many open files' or 'no space left on device' you probably need to increase the number of inotify watches you're allowed.")
+    watched, notWatched := counts.get()
+    fmt.Fprintf(status, "Watching %d directories..\n", watched)
+    if notWatched > 0 {
+        fmt.Fprintf(status, "Could not watch %d directories.\n", notWatched)
+        fmt.Fprintln(status, "If you got 'permission denied errors', try running as root.")
+        fmt.Fprintln(status, "If you got 'too many open files' or 'no space left on device' you probably need to increase the number of inotify watches you're allowed.")
     }
 
-    fmt.Println("Press enter to start recording:")
-    reader := bufio.NewReader(os.Stdin)
-    reader.ReadString('\n')
+    if (*noWaitFlag) == false && command == nil {
+        fmt.Fprintln(status, "Press enter to start recording:")
+        reader := bufio.NewReader(os.Stdin)
+        reader.ReadString('\n')
+    }
 
     // now tell goroutine to start recording things
-    fmt.Println("Beginning to record events. Press Ctrl-C to stop..")
-    readyChannel <- true
+    if command != nil {
+        fmt.Fprintf(status, "Beginning to record events while running %v..\n", strings.Join(command, " "))
+    } else {
+        fmt.Fprintln(status, "Beginning to record events. Press Ctrl-C to stop..")
+    }
+    readyChannel <- walked
+    setStartTime(time.Now())
+
+    // the command only starts once recording has, so that none of its events are missed
+    var wrapped *wrappedCommand
+    var commandDone chan int
+    if command != nil {
+        wrapped, err = startWrappedCommand(command)
+        if err != nil {
+            fmt.Fprintf(status, "Could not run %v: %v\n", command[0], err.Error())
+            os.Exit(1)
+        }
+        commandDone = wrapped.Done
+    }
+    exitStatus := 0
+
+    // a nil channel never fires, so without -duration only a signal or -max-events stops the capture
+    var durationChannel <-chan time.Time
+    if *durationFlag > 0 {
+        durationChannel = time.After(*durationFlag)
+    }
 
     // instead of sitting in a for loop or something, we wait for sigint
     signalChannel := make(chan os.Signal, 1)
     // notify that we are going to handle interrupts
-    signal.Notify(signalChannel, os.Interrupt)
-    for sig := range signalChannel {
-        fmt.Printf("Received %v signal. Stopping.\n", sig)
+    signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+    if snapshotSignal != nil {
+        signal.Notify(signalChannel, snapshotSignal, resetSignal, reloadSignal)
+    }
+    for {
+        var reason string
+        select {
+        case <- durationChannel:
+            reason = fmt.Sprintf("Capture duration of %v reached", *durationFlag)
+        case <- limitChannel:
+            reason = fmt.Sprintf("Recorded %d events", *maxEventsFlag)
+        case exitStatus = <- commandDone:
+            reason = fmt.Sprintf("Command exited with status %d", exitStatus)
+            // give the watcher a moment to deliver the last events the command caused
+            settle := commandSettle
+            if *backendFlag == "polling" {
+                // the last changes only show up on the next scan
+                settle += *pollIntervalFlag
+            }
+            time.Sleep(settle)
+        case sig := <- signalChannel:
+            switch sig {
+            case snapshotSignal:
+                now := time.Now()
+                fmt.Fprintf(status, "Received %v signal. Taking a snapshot, recording continues.\n", sig)
+                err := summary.DoSummary(box, currentCapture(now), summaryOptions.Suffixed(now.Format("-20060102T150405")))
+                if err != nil {
+                    fmt.Fprintf(status, "Error: %s\n", err.Error())
+                }
+                continue
+            case resetSignal:
+                box.Reset()
+                setStartTime(time.Now())
+                fmt.Fprintf(status, "Received %v signal. Counts reset, recording continues.\n", sig)
+                continue
+            case reloadSignal:
+                if ignorePrefixFile == "" {
+                    fmt.Fprintf(status, "Received %v signal. There is no -ignore-prefixes file to reload.\n", sig)
+                    continue
+                }
+                fmt.Fprintf(status, "Received %v signal. Reloading ignore prefixes.\n", sig)
+                prefixes, err := loadIgnorePrefixes(ignorePrefixFile)
+                if err != nil { continue }
+                reloadChannel <- prefixes
+                continue
+            }
+            if wrapped != nil {
+                // the capture stops once the command exits
+                if sig == os.Interrupt && inForeground() {
+                    // a Ctrl-C has reached the command already, a second one can make it skip its cleanup
+                    fmt.Fprintf(status, "Received %v signal. Waiting for the command to exit.\n", sig)
+                    continue
+                }
+                fmt.Fprintf(status, "Received %v signal. Passing it on to the command.\n", sig)
+                wrapped.Signal(sig)
+                continue
+            }
+            reason = fmt.Sprintf("Received %v signal", sig)
+        }
+
+        fmt.Fprintf(status, "%s. Stopping.\n", reason)
+        stopTime := time.Now()
         stopChannel <- true
+        <- stoppedChannel
+
+        if runner != nil {
+            // nothing new runs while the summary is written
+            runner.Close()
+        }
+
+        if recorder != nil {
+            err := recorder.Close()
+            if err != nil {
+                fmt.Fprintf(status, "Error writing session file %v: %v\n", *recordFileFlag, err.Error())
+            }
+        }
 
-        fmt.Printf("Stopping inotify watcher..\n")
+        fmt.Fprintf(status, "Stopping watcher..\n")
         watcher.Close()
 
         // print and output summary infos
-        err := summary.DoSummary(box, recordMask, *sortByNameFlag, *exportCSVFlag)
+        capture := currentCapture(stopTime)
+        err := summary.DoSummary(box, capture, summaryOptions)
         if err != nil {
-            fmt.Printf("Error: %s\n", err.Error())
-            os.Exit(1)
+            fmt.Fprintf(status, "Error: %s\n", err.Error())
+            exitStatus = 1
         }
 
-        os.Exit(0)
+        if runner != nil {
+            fmt.Fprintln(status, "Stopping running -exec commands..")
+            terminated := make(chan bool)
+            go func() {
+                runner.Terminate(execStopGrace)
+                close(terminated)
+            }()
+            // another interrupt kills them without waiting out the grace period
+            for waiting := true; waiting; {
+                select {
+                case <- terminated:
+                    waiting = false
+                case sig := <- signalChannel:
+                    if sig == os.Interrupt || sig == syscall.SIGTERM {
+                        runner.Kill()
+                    }
+                }
+            }
+        }
+
+        os.Exit(exitStatus)
     }
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestUnignoredParents(t *testing.T) {
+    tests := []struct {
+        name string
+        root string
+        old []string
+        new []string
+        want []string
+    }{
+        {"nothing changed", "/t", []string{"/t/a"}, []string{"/t/a"}, nil},
+        {"prefix dropped", "/t", []string{"/t/a"}, nil, []string{"/t"}},
+        {"partial name", "/t", []string{"/t/fo"}, []string{}, []string{"/t"}},
+        {"trailing slash covers the directory contents", "/t", []string{"/t/a/"}, nil, []string{"/t/a"}},
+        {"siblings share a parent", "/t", []string{"/t/a", "/t/b"}, nil, []string{"/t"}},
+        {"nested prefixes are walked from the outer one", "/t", []string{"/t/a", "/t/a/b"}, nil, []string{"/t"}},
+        {"a still ignored parent isn't read", "/t", []string{"/t/a", "/t/a/b"}, []string{"/t/a"}, nil},
+        {"a newly ignored parent isn't read", "/t", []string{"/t/a/b"}, []string{"/t/a"}, nil},
+        {"outside the root", "/t", []string{"/other/a", "/tt/a"}, nil, nil},
+        {"the root itself", "/t", []string{"/t/"}, nil, []string{"/t"}},
+        {"filesystem root", "/", []string{"/a"}, nil, []string{"/"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := unignoredParents(tt.root, tt.old, tt.new)
+            if reflect.DeepEqual(got, tt.want) == false {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,101 @@
+package live
+
+import (
+    "sort"
+    "sync"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+type coalescedEvent struct {
+    event fsnotify.Event
+    count int
+    timer *time.Timer
+}
+
+// Coalescer wraps another printer and merges the events for a path that
+// arrive within a window of the first one into a single line, with the union
+// of their ops and a count.
+type Coalescer struct {
+    inner Printer
+    window time.Duration
+
+    mu sync.Mutex
+    pending map[string]*coalescedEvent
+}
+
+func NewCoalescer(inner Printer, window time.Duration) *Coalescer {
+    return &Coalescer{
+        inner: inner,
+        window: window,
+        pending: make(map[string]*coalescedEvent),
+    }
+}
+
+func (c *Coalescer) Event(e *fsnotify.Event, count int) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    ce, ok := c.pending[e.Name]
+    if ok {
+        ce.event.Op |= e.Op
+        ce.count += count
+        return
+    }
+    ce = &coalescedEvent{event: *e, count: count}
+    ce.timer = time.AfterFunc(c.window, func() { c.expire(e.Name, ce) })
+    c.pending[e.Name] = ce
+}
+
+func (c *Coalescer) expire(name string, ce *coalescedEvent) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    // it may already have been flushed, and replaced by a newer burst
+    if c.pending[name] != ce { return }
+    c.flushPath(name)
+}
+
+// flushPath prints the pending burst for a path, the caller must hold c.mu.
+func (c *Coalescer) flushPath(name string) {
+    ce, ok := c.pending[name]
+    if ok == false { return }
+    ce.timer.Stop()
+    delete(c.pending, name)
+    c.inner.Event(&ce.event, ce.count)
+}
+
+// Moves and overflows are not merged, but pending bursts for the same paths
+// are printed first to keep the output in order.
+func (c *Coalescer) Move(m fileevents.Move) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.flushPath(m.From)
+    c.flushPath(m.To)
+    c.inner.Move(m)
+}
+
+func (c *Coalescer) Overflow(t time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.inner.Overflow(t)
+}
+
+// Flush prints every pending burst, for when recording stops.
+func (c *Coalescer) Flush() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    var names []string
+    for name := range c.pending {
+        names = append(names, name)
+    }
+    // print them in the order the bursts started
+    sort.Slice(names, func(i, j int) bool {
+        return c.pending[names[i]].event.Time.Before(c.pending[names[j]].event.Time)
+    })
+    for _, name := range names {
+        c.flushPath(name)
+    }
+}
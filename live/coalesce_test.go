@@ -0,0 +1,121 @@
+package live
+
+import (
+    "fmt"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+// recorder is a Printer that keeps one line per call.
+type recorder struct {
+    mu sync.Mutex
+    lines []string
+}
+
+func (r *recorder) Event(e *fsnotify.Event, count int) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.lines = append(r.lines, fmt.Sprintf("%s %s x%d", e.Name, strings.Join(fileevents.OpNames(e.Op), ","), count))
+}
+
+func (r *recorder) Move(m fileevents.Move) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.lines = append(r.lines, "move " + m.From + " " + m.To)
+}
+
+func (r *recorder) Overflow(t time.Time) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.lines = append(r.lines, "overflow")
+}
+
+func (r *recorder) Lines() []string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([]string(nil), r.lines...)
+}
+
+func TestCoalescer(t *testing.T) {
+    at := time.Now()
+    ev := func(name string, op fsnotify.Op, offset int) *fsnotify.Event {
+        return &fsnotify.Event{Name: name, Op: op, Time: at.Add(time.Duration(offset) * time.Millisecond)}
+    }
+    tests := []struct {
+        name string
+        run func(c *Coalescer)
+        want []string
+    }{
+        {
+            name: "a burst is one line",
+            run: func(c *Coalescer) {
+                c.Event(ev("/a", fsnotify.Create, 0), 1)
+                c.Event(ev("/a", fsnotify.Write, 1), 1)
+                c.Event(ev("/a", fsnotify.Write, 2), 2)
+                c.Flush()
+            },
+            want: []string{"/a Create,Write x4"},
+        },
+        {
+            name: "flush prints in the order the bursts started",
+            run: func(c *Coalescer) {
+                c.Event(ev("/b", fsnotify.Write, 0), 1)
+                c.Event(ev("/a", fsnotify.Write, 1), 1)
+                c.Event(ev("/c", fsnotify.Write, 2), 1)
+                c.Flush()
+            },
+            want: []string{"/b Write x1", "/a Write x1", "/c Write x1"},
+        },
+        {
+            name: "a move prints its paths first",
+            run: func(c *Coalescer) {
+                c.Event(ev("/a", fsnotify.Write, 0), 1)
+                c.Event(ev("/other", fsnotify.Write, 1), 1)
+                c.Move(fileevents.Move{From: "/a", To: "/b"})
+                c.Flush()
+            },
+            want: []string{"/a Write x1", "move /a /b", "/other Write x1"},
+        },
+        {
+            name: "overflows aren't held back",
+            run: func(c *Coalescer) {
+                c.Event(ev("/a", fsnotify.Write, 0), 1)
+                c.Overflow(at)
+                c.Flush()
+            },
+            want: []string{"overflow", "/a Write x1"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := &recorder{}
+            tt.run(NewCoalescer(r, time.Hour))
+            if got := r.Lines(); reflect.DeepEqual(got, tt.want) == false {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestCoalescerWindow(t *testing.T) {
+    r := &recorder{}
+    c := NewCoalescer(r, 50 * time.Millisecond)
+    c.Event(&fsnotify.Event{Name: "/a", Op: fsnotify.Write}, 1)
+    c.Event(&fsnotify.Event{Name: "/a", Op: fsnotify.Write}, 1)
+    time.Sleep(150 * time.Millisecond)
+
+    // a new burst starts once the window is over
+    c.Event(&fsnotify.Event{Name: "/a", Op: fsnotify.Chmod}, 1)
+    time.Sleep(150 * time.Millisecond)
+    want := []string{"/a Write x2", "/a Chmod x1"}
+    if got := r.Lines(); reflect.DeepEqual(got, want) == false {
+        t.Errorf("got %q, want %q", got, want)
+    }
+}
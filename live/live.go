@@ -0,0 +1,126 @@
+package live
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/procinfo"
+)
+
+// Printer writes recorded events out as they happen, for -live. Count is the
+// number of raw events merged into e, which is more than one when coalescing.
+type Printer interface {
+    Event(e *fsnotify.Event, count int)
+    Move(m fileevents.Move)
+    Overflow(t time.Time)
+}
+
+// TimeFormat is used to timestamp each text line
+const TimeFormat = "15:04:05.000000"
+
+// TextPrinter writes the human readable "event:" lines.
+type TextPrinter struct {
+    out io.Writer
+}
+
+func NewTextPrinter(out io.Writer) *TextPrinter {
+    return &TextPrinter{out: out}
+}
+
+func (p *TextPrinter) Event(e *fsnotify.Event, count int) {
+    suffix := ""
+    if e.Pid != 0 {
+        suffix += " by " + procinfo.Lookup(e.Pid).String()
+    }
+    if count > 1 {
+        suffix += fmt.Sprintf(" (x%d)", count)
+    }
+    fmt.Fprintf(p.out, "%s event: %v%s\n", e.Time.Format(TimeFormat), e.String(), suffix)
+}
+
+func (p *TextPrinter) Move(m fileevents.Move) {
+    fmt.Fprintf(p.out, "%s event: %v\n", m.Time.Format(TimeFormat), m.String())
+}
+
+func (p *TextPrinter) Overflow(t time.Time) {
+    fmt.Fprintf(p.out, "%s overflow: the inotify queue overflowed, events were dropped\n", t.Format(TimeFormat))
+}
+
+type jsonEvent struct {
+    Time time.Time `json:"time"`
+    Path string `json:"path,omitempty"`
+    From string `json:"from,omitempty"`
+    To string `json:"to,omitempty"`
+    Ops []string `json:"ops"`
+    IsDir bool `json:"is_dir"`
+    Root string `json:"root"`
+    Count int `json:"count"`
+    Pid int `json:"pid,omitempty"`
+    Comm string `json:"comm,omitempty"`
+    Exe string `json:"exe,omitempty"`
+}
+
+// JSONPrinter writes one json object per line so the stream can be piped
+// into other tools.
+type JSONPrinter struct {
+    encoder *json.Encoder
+    root string
+}
+
+// NewJSONPrinter creates a printer whose events all carry the given watched root.
+func NewJSONPrinter(out io.Writer, root string) *JSONPrinter {
+    return &JSONPrinter{encoder: json.NewEncoder(out), root: root}
+}
+
+func (p *JSONPrinter) Event(e *fsnotify.Event, count int) {
+    je := jsonEvent{
+        Time: e.Time,
+        Path: e.Name,
+        Ops: fileevents.OpNames(e.Op),
+        IsDir: e.IsDir,
+        Root: p.root,
+        Count: count,
+    }
+    if e.Pid != 0 {
+        proc := procinfo.Lookup(e.Pid)
+        je.Pid, je.Comm, je.Exe = proc.Pid, proc.Comm, proc.Exe
+    }
+    p.encoder.Encode(je)
+}
+
+func (p *JSONPrinter) Move(m fileevents.Move) {
+    je := jsonEvent{
+        Time: m.Time,
+        Path: m.Path(),
+        From: m.From,
+        To: m.To,
+        Ops: []string{m.Kind()},
+        IsDir: m.IsDir,
+        Root: p.root,
+        Count: 1,
+    }
+    p.encoder.Encode(je)
+}
+
+func (p *JSONPrinter) Overflow(t time.Time) {
+    p.encoder.Encode(jsonEvent{Time: t, Ops: []string{"Overflow"}, Root: p.root, Count: 1})
+}
+
+// Tee sends every event to each of its printers in turn.
+type Tee []Printer
+
+func (t Tee) Event(e *fsnotify.Event, count int) {
+    for _, p := range t { p.Event(e, count) }
+}
+
+func (t Tee) Move(m fileevents.Move) {
+    for _, p := range t { p.Move(m) }
+}
+
+func (t Tee) Overflow(at time.Time) {
+    for _, p := range t { p.Overflow(at) }
+}
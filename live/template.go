@@ -0,0 +1,110 @@
+package live
+
+import (
+    "fmt"
+    "io"
+    "path/filepath"
+    "strings"
+    "text/template"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/procinfo"
+)
+
+// TemplateData is what a -live-template is executed against for each event.
+type TemplateData struct {
+    Time time.Time
+    Path string
+    RelPath string
+    Dir string
+    Base string
+    Ext string
+    Ops []string
+    IsDir bool
+    Root string
+    // Count is the number of raw events merged into this one
+    Count int
+
+    // only set for moves
+    From string
+    To string
+
+    // only set when the backend knows the process behind the event
+    Pid int
+    Comm string
+    Exe string
+}
+
+var templateFuncs = template.FuncMap{
+    "join": strings.Join,
+}
+
+// ParseTemplate parses a -live-template, making a "join" function available
+// for formatting .Ops.
+func ParseTemplate(text string) (*template.Template, error) {
+    return template.New("live").Funcs(templateFuncs).Parse(text)
+}
+
+// TemplatePrinter writes each event through a user supplied template, one
+// line per event.
+type TemplatePrinter struct {
+    out io.Writer
+    tmpl *template.Template
+    root string
+}
+
+func NewTemplatePrinter(out io.Writer, tmpl *template.Template, root string) *TemplatePrinter {
+    return &TemplatePrinter{out: out, tmpl: tmpl, root: root}
+}
+
+func (p *TemplatePrinter) data(t time.Time, path string, ops []string, isDir bool) TemplateData {
+    d := TemplateData{
+        Time: t,
+        Path: path,
+        Ops: ops,
+        IsDir: isDir,
+        Root: p.root,
+        Count: 1,
+    }
+    if path != "" {
+        d.RelPath = path
+        if rel, err := filepath.Rel(p.root, path); err == nil { d.RelPath = rel }
+        d.Dir = filepath.Dir(path)
+        d.Base = filepath.Base(path)
+        d.Ext = filepath.Ext(path)
+    }
+    return d
+}
+
+func (p *TemplatePrinter) write(d TemplateData) {
+    var line strings.Builder
+    err := p.tmpl.Execute(&line, d)
+    if err != nil {
+        fmt.Fprintf(p.out, "template error: %v\n", err)
+        return
+    }
+    io.WriteString(p.out, strings.TrimSuffix(line.String(), "\n") + "\n")
+}
+
+func (p *TemplatePrinter) Event(e *fsnotify.Event, count int) {
+    d := p.data(e.Time, e.Name, fileevents.OpNames(e.Op), e.IsDir)
+    d.Count = count
+    if e.Pid != 0 {
+        proc := procinfo.Lookup(e.Pid)
+        d.Pid, d.Comm, d.Exe = proc.Pid, proc.Comm, proc.Exe
+    }
+    p.write(d)
+}
+
+func (p *TemplatePrinter) Move(m fileevents.Move) {
+    d := p.data(m.Time, m.Path(), []string{m.Kind()}, m.IsDir)
+    d.From = m.From
+    d.To = m.To
+    p.write(d)
+}
+
+func (p *TemplatePrinter) Overflow(t time.Time) {
+    p.write(p.data(t, "", []string{"Overflow"}, false))
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+// Reasons an event was seen but not recorded.
+const (
+    DroppedNotRecording = "not_recording"
+    DroppedMasked = "masked"
+)
+
+// otherGroup is the group for paths that match none of the prefixes
+const otherGroup = "other"
+
+// Metrics keeps counters for the Prometheus endpoint. Unlike the EventBox
+// they only ever go up, as Prometheus expects.
+type Metrics struct {
+    mu sync.Mutex
+    prefixes []string
+    byOp map[fsnotify.Op]uint64
+    byGroup map[string]uint64
+    errors uint64
+    overflows uint64
+    dropped map[string]uint64
+    watches func() (int, int)
+}
+
+// New creates the counters. Recorded events are also counted by the first of
+// the prefixes their path starts with, and watches reports the number of
+// watched and not watched directories when scraped.
+func New(prefixes []string, watches func() (int, int)) *Metrics {
+    m := &Metrics{
+        prefixes: prefixes,
+        byOp: make(map[fsnotify.Op]uint64),
+        byGroup: make(map[string]uint64),
+        dropped: make(map[string]uint64),
+        watches: watches,
+    }
+    // always export the groups, even before their first event
+    for _, prefix := range prefixes {
+        m.byGroup[prefix] = 0
+    }
+    m.byGroup[otherGroup] = 0
+    return m
+}
+
+func (m *Metrics) group(path string) string {
+    for _, prefix := range m.prefixes {
+        if strings.HasPrefix(path, prefix) { return prefix }
+    }
+    return otherGroup
+}
+
+// Event counts a recorded event once for each op it carries.
+func (m *Metrics) Event(path string, op fsnotify.Op) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, o := range fileevents.Ops {
+        if op & o == o { m.byOp[o]++ }
+    }
+    m.byGroup[m.group(path)]++
+}
+
+func (m *Metrics) Dropped(reason string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.dropped[reason]++
+}
+
+func (m *Metrics) Error() {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.errors++
+}
+
+func (m *Metrics) Overflow() {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.overflows++
+}
+
+func sortedKeys(counts map[string]uint64) []string {
+    var keys []string
+    for k := range counts {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    watched, notWatched := m.watches()
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+    fmt.Fprintln(w, "# HELP inotify_spy_events_total Recorded events by operation.")
+    fmt.Fprintln(w, "# TYPE inotify_spy_events_total counter")
+    for _, op := range fileevents.Ops {
+        fmt.Fprintf(w, "inotify_spy_events_total{op=%q} %d\n", fileevents.OpName(op), m.byOp[op])
+    }
+
+    fmt.Fprintln(w, "# HELP inotify_spy_group_events_total Recorded events by path prefix group.")
+    fmt.Fprintln(w, "# TYPE inotify_spy_group_events_total counter")
+    for _, group := range sortedKeys(m.byGroup) {
+        fmt.Fprintf(w, "inotify_spy_group_events_total{group=%q} %d\n", group, m.byGroup[group])
+    }
+
+    fmt.Fprintln(w, "# HELP inotify_spy_dropped_events_total Events that were seen but not recorded.")
+    fmt.Fprintln(w, "# TYPE inotify_spy_dropped_events_total counter")
+    for _, reason := range []string{DroppedNotRecording, DroppedMasked} {
+        fmt.Fprintf(w, "inotify_spy_dropped_events_total{reason=%q} %d\n", reason, m.dropped[reason])
+    }
+
+    fmt.Fprintln(w, "# HELP inotify_spy_queue_overflows_total Times the kernel event queue overflowed and dropped events.")
+    fmt.Fprintln(w, "# TYPE inotify_spy_queue_overflows_total counter")
+    fmt.Fprintf(w, "inotify_spy_queue_overflows_total %d\n", m.overflows)
+
+    fmt.Fprintln(w, "# HELP inotify_spy_errors_total Errors reported by the watcher.")
+    fmt.Fprintln(w, "# TYPE inotify_spy_errors_total counter")
+    fmt.Fprintf(w, "inotify_spy_errors_total %d\n", m.errors)
+
+    fmt.Fprintln(w, "# HELP inotify_spy_watched_directories Directories being watched.")
+    fmt.Fprintln(w, "# TYPE inotify_spy_watched_directories gauge")
+    fmt.Fprintf(w, "inotify_spy_watched_directories %d\n", watched)
+
+    fmt.Fprintln(w, "# HELP inotify_spy_failed_directories Directories that could not be watched.")
+    fmt.Fprintln(w, "# TYPE inotify_spy_failed_directories gauge")
+    fmt.Fprintf(w, "inotify_spy_failed_directories %d\n", notWatched)
+}
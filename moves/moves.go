@@ -0,0 +1,72 @@
+package moves
+
+import (
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+// DefaultWindow is how long the first half of a move waits for its partner.
+// The kernel normally queues both halves back to back, so this can be short.
+const DefaultWindow = 100 * time.Millisecond
+
+type pendingMove struct {
+    from string
+    seen time.Time
+    isDir bool
+}
+
+// Pairer correlates the moved-from and moved-to halves of a move using the
+// cookie inotify gives them. It is not safe for concurrent use.
+type Pairer struct {
+    window time.Duration
+    pending map[uint32]pendingMove
+}
+
+func NewPairer(window time.Duration) *Pairer {
+    return &Pairer{
+        window: window,
+        pending: make(map[uint32]pendingMove),
+    }
+}
+
+// Add takes an event with a non-zero cookie. The moved-from half is held back
+// until its partner arrives, so the bool result is only true once a move is
+// complete. A moved-to half without a partner is a move into the tree.
+func (p *Pairer) Add(e *fsnotify.Event, now time.Time) (fileevents.Move, bool) {
+    if e.Op & fsnotify.Rename == fsnotify.Rename {
+        p.pending[e.Cookie] = pendingMove{from: e.Name, seen: now, isDir: e.IsDir}
+        return fileevents.Move{}, false
+    }
+
+    pm, ok := p.pending[e.Cookie]
+    if ok == false {
+        return fileevents.Move{To: e.Name, Time: now, IsDir: e.IsDir}, true
+    }
+    delete(p.pending, e.Cookie)
+    return fileevents.Move{From: pm.from, To: e.Name, Time: now, IsDir: e.IsDir}, true
+}
+
+// Expire returns the moved-from halves that waited longer than the window
+// without a partner. Those files were moved out of the tree.
+func (p *Pairer) Expire(now time.Time) []fileevents.Move {
+    var out []fileevents.Move
+    for cookie, pm := range p.pending {
+        if now.Sub(pm.seen) >= p.window {
+            out = append(out, fileevents.Move{From: pm.from, Time: pm.seen, IsDir: pm.isDir})
+            delete(p.pending, cookie)
+        }
+    }
+    return out
+}
+
+// Flush returns every remaining moved-from half as a move out of the tree.
+func (p *Pairer) Flush() []fileevents.Move {
+    var out []fileevents.Move
+    for cookie, pm := range p.pending {
+        out = append(out, fileevents.Move{From: pm.from, Time: pm.seen, IsDir: pm.isDir})
+        delete(p.pending, cookie)
+    }
+    return out
+}
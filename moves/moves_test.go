@@ -0,0 +1,118 @@
+package moves
+
+import (
+    "testing"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+func TestPairerAdd(t *testing.T) {
+    start := time.Unix(1000, 0)
+    tests := []struct {
+        name string
+        events []fsnotify.Event
+        want []fileevents.Move
+    }{
+        {
+            name: "both halves make one move",
+            events: []fsnotify.Event{
+                {Name: "/a", Op: fsnotify.Rename, Cookie: 1},
+                {Name: "/b", Op: fsnotify.Create, Cookie: 1},
+            },
+            want: []fileevents.Move{{From: "/a", To: "/b", Time: start}},
+        },
+        {
+            name: "a moved-to half alone is a move into the tree",
+            events: []fsnotify.Event{
+                {Name: "/b", Op: fsnotify.Create, Cookie: 2, IsDir: true},
+            },
+            want: []fileevents.Move{{To: "/b", Time: start, IsDir: true}},
+        },
+        {
+            name: "cookies keep interleaved moves apart",
+            events: []fsnotify.Event{
+                {Name: "/a", Op: fsnotify.Rename, Cookie: 1},
+                {Name: "/c", Op: fsnotify.Rename, Cookie: 2},
+                {Name: "/d", Op: fsnotify.Create, Cookie: 2},
+                {Name: "/b", Op: fsnotify.Create, Cookie: 1},
+            },
+            want: []fileevents.Move{
+                {From: "/c", To: "/d", Time: start},
+                {From: "/a", To: "/b", Time: start},
+            },
+        },
+        {
+            name: "a moved-from half alone waits",
+            events: []fsnotify.Event{
+                {Name: "/a", Op: fsnotify.Rename, Cookie: 1},
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p := NewPairer(DefaultWindow)
+            var got []fileevents.Move
+            for i := range tt.events {
+                m, complete := p.Add(&tt.events[i], start)
+                if complete { got = append(got, m) }
+            }
+            if len(got) != len(tt.want) {
+                t.Fatalf("got %d moves %v, want %d", len(got), got, len(tt.want))
+            }
+            for i := range got {
+                if got[i] != tt.want[i] {
+                    t.Errorf("move %d: got %+v, want %+v", i, got[i], tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestPairerExpire(t *testing.T) {
+    start := time.Unix(1000, 0)
+    p := NewPairer(100 * time.Millisecond)
+    p.Add(&fsnotify.Event{Name: "/old", Op: fsnotify.Rename, Cookie: 1, IsDir: true}, start)
+    p.Add(&fsnotify.Event{Name: "/new", Op: fsnotify.Rename, Cookie: 2}, start.Add(80 * time.Millisecond))
+
+    if got := p.Expire(start.Add(50 * time.Millisecond)); len(got) != 0 {
+        t.Fatalf("expired %v before the window passed", got)
+    }
+
+    got := p.Expire(start.Add(100 * time.Millisecond))
+    want := fileevents.Move{From: "/old", Time: start, IsDir: true}
+    if len(got) != 1 || got[0] != want {
+        t.Fatalf("got %v, want [%+v]", got, want)
+    }
+
+    // the expired half no longer pairs up
+    m, complete := p.Add(&fsnotify.Event{Name: "/b", Op: fsnotify.Create, Cookie: 1}, start.Add(time.Second))
+    if complete == false || m.From != "" {
+        t.Errorf("got %+v after expiry, want a move into the tree", m)
+    }
+}
+
+func TestPairerFlush(t *testing.T) {
+    start := time.Unix(1000, 0)
+    p := NewPairer(DefaultWindow)
+    p.Add(&fsnotify.Event{Name: "/a", Op: fsnotify.Rename, Cookie: 1}, start)
+    p.Add(&fsnotify.Event{Name: "/b", Op: fsnotify.Rename, Cookie: 2}, start)
+
+    got := p.Flush()
+    if len(got) != 2 {
+        t.Fatalf("got %v, want both pending halves", got)
+    }
+    froms := map[string]bool{}
+    for _, m := range got {
+        if m.To != "" { t.Errorf("flushed %+v has a destination", m) }
+        froms[m.From] = true
+    }
+    if froms["/a"] == false || froms["/b"] == false {
+        t.Errorf("got %v, want moves out of /a and /b", got)
+    }
+    if again := p.Flush(); len(again) != 0 {
+        t.Errorf("second flush returned %v", again)
+    }
+}
@@ -0,0 +1,240 @@
+package polling
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// fileState is what is compared between scans to spot a change.
+type fileState struct {
+    size int64
+    mode os.FileMode
+    mtime time.Time
+    ctime time.Time
+    ino uint64
+}
+
+func stateOf(info os.FileInfo) fileState {
+    ino, ctime := inodeAndCtime(info)
+    return fileState{
+        size: info.Size(),
+        mode: info.Mode(),
+        mtime: info.ModTime(),
+        ctime: ctime,
+        ino: ino,
+    }
+}
+
+// Watcher finds changes by scanning its directories every interval, for
+// filesystems where inotify sees nothing, like NFS or FUSE. It can only
+// report Create, Write, Remove and Chmod, and changes that are undone before
+// the next scan are missed.
+type Watcher struct {
+    Events chan fsnotify.Event
+    Errors chan error
+
+    maxFiles int
+    done chan struct{}
+
+    mu sync.Mutex
+    // dirs maps each watched directory to the state of itself, under ".", and its entries
+    dirs map[string]map[string]fileState
+    warnedMaxFiles bool
+}
+
+// NewWatcher starts a watcher that scans every interval, looking at no more
+// than maxFiles files per scan, or any number if maxFiles is zero.
+func NewWatcher(interval time.Duration, maxFiles int) (*Watcher, error) {
+    if interval <= 0 { return nil, errors.New("polling interval must be positive") }
+
+    w := &Watcher{
+        Events: make(chan fsnotify.Event),
+        Errors: make(chan error),
+        maxFiles: maxFiles,
+        done: make(chan struct{}),
+        dirs: make(map[string]map[string]fileState),
+    }
+    go w.poll(interval)
+    return w, nil
+}
+
+// Add starts watching a directory and the files directly inside it. What
+// is there now is the starting point, only later changes are reported.
+func (w *Watcher) Add(name string) error {
+    name = filepath.Clean(name)
+    states, err := scanDir(name)
+    if err != nil { return err }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.dirs[name] = states
+    return nil
+}
+
+func (w *Watcher) Remove(name string) error {
+    name = filepath.Clean(name)
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    _, ok := w.dirs[name]
+    if ok == false {
+        return errors.New("can't remove non-existent polling watch for: " + name)
+    }
+    delete(w.dirs, name)
+    return nil
+}
+
+func (w *Watcher) WatchList() []string {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    entries := make([]string, 0, len(w.dirs))
+    for name := range w.dirs {
+        entries = append(entries, name)
+    }
+    return entries
+}
+
+func (w *Watcher) Close() error {
+    select {
+    case <- w.done:
+    default:
+        close(w.done)
+    }
+    return nil
+}
+
+func (w *Watcher) poll(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <- w.done:
+            return
+        case <- ticker.C:
+            events, err := w.scan()
+            if err != nil {
+                select {
+                case w.Errors <- err:
+                case <- w.done: return
+                }
+            }
+            // sent without the lock held, since handling a new directory calls Add
+            for _, e := range events {
+                select {
+                case w.Events <- e:
+                case <- w.done: return
+                }
+            }
+        }
+    }
+}
+
+// scan compares every watched directory with the previous scan.
+func (w *Watcher) scan() ([]fsnotify.Event, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    var events []fsnotify.Event
+    var err error
+    now := time.Now()
+    scanned := 0
+    // in a fixed order, so the same files are left out when there are too many
+    dirs := make([]string, 0, len(w.dirs))
+    for dir := range w.dirs {
+        dirs = append(dirs, dir)
+    }
+    sort.Strings(dirs)
+    for _, dir := range dirs {
+        before := w.dirs[dir]
+        if w.maxFiles > 0 && scanned >= w.maxFiles {
+            if w.warnedMaxFiles == false {
+                err = fmt.Errorf("polling: stopped after scanning %d files, some changes will be missed", w.maxFiles)
+                w.warnedMaxFiles = true
+            }
+            break
+        }
+
+        after, scanErr := scanDir(dir)
+        if scanErr != nil {
+            // like inotify, a directory that is gone stops being watched
+            for _, name := range sortedNames(before) {
+                state := before[name]
+                if name == "." { continue }
+                events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove, Time: now, IsDir: state.mode.IsDir()})
+            }
+            delete(w.dirs, dir)
+            continue
+        }
+        scanned += len(after)
+
+        for _, name := range sortedNames(after) {
+            state := after[name]
+            path := filepath.Join(dir, name)
+            isDir := state.mode.IsDir()
+            old, existed := before[name]
+            switch {
+            case existed == false:
+                events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create, Time: now, IsDir: isDir})
+            case old.ino != state.ino:
+                // replaced, such as by a rename over the top of it
+                events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove, Time: now, IsDir: old.mode.IsDir()})
+                events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create, Time: now, IsDir: isDir})
+            default:
+                // a file can be both written and chmodded between scans
+                written := isDir == false && (old.size != state.size || old.mtime.Equal(state.mtime) == false)
+                if written {
+                    events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write, Time: now})
+                }
+                // the ctime also moves for ownership changes, which inotify reports as a
+                // chmod, and for writes, which leave it equal to the mtime
+                changed := isDir == false && old.ctime.Equal(state.ctime) == false
+                if old.mode != state.mode || (changed && (written == false || state.ctime.After(state.mtime))) {
+                    events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Chmod, Time: now, IsDir: isDir})
+                }
+            }
+        }
+        for _, name := range sortedNames(before) {
+            state := before[name]
+            _, exists := after[name]
+            if exists == false {
+                events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove, Time: now, IsDir: state.mode.IsDir()})
+            }
+        }
+        w.dirs[dir] = after
+    }
+    return events, err
+}
+
+// scanDir records the state of a directory, under ".", and of its entries.
+func scanDir(dir string) (map[string]fileState, error) {
+    info, err := os.Lstat(dir)
+    if err != nil { return nil, err }
+    if info.IsDir() == false {
+        return map[string]fileState{".": stateOf(info)}, nil
+    }
+
+    entries, err := ioutil.ReadDir(dir)
+    if err != nil { return nil, err }
+    states := make(map[string]fileState, len(entries) + 1)
+    states["."] = stateOf(info)
+    for _, entry := range entries {
+        states[entry.Name()] = stateOf(entry)
+    }
+    return states, nil
+}
+
+func sortedNames(states map[string]fileState) []string {
+    names := make([]string, 0, len(states))
+    for name := range states {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
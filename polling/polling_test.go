@@ -0,0 +1,123 @@
+package polling
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+    "github.com/fsnotify/fsnotify"
+)
+
+func TestScan(t *testing.T) {
+    tests := []struct {
+        name string
+        setup func(dir string) error
+        change func(dir string) error
+        want []fsnotify.Op
+    }{
+        {
+            name: "create",
+            change: func(dir string) error { return ioutil.WriteFile(filepath.Join(dir, "f"), []byte("a"), 0644) },
+            want: []fsnotify.Op{fsnotify.Create},
+        },
+        {
+            name: "remove",
+            setup: func(dir string) error { return ioutil.WriteFile(filepath.Join(dir, "f"), []byte("a"), 0644) },
+            change: func(dir string) error { return os.Remove(filepath.Join(dir, "f")) },
+            want: []fsnotify.Op{fsnotify.Remove},
+        },
+        {
+            name: "write",
+            setup: func(dir string) error { return ioutil.WriteFile(filepath.Join(dir, "f"), []byte("a"), 0644) },
+            change: func(dir string) error { return ioutil.WriteFile(filepath.Join(dir, "f"), []byte("abc"), 0644) },
+            want: []fsnotify.Op{fsnotify.Write},
+        },
+        {
+            name: "chmod",
+            setup: func(dir string) error { return ioutil.WriteFile(filepath.Join(dir, "f"), []byte("a"), 0644) },
+            change: func(dir string) error { return os.Chmod(filepath.Join(dir, "f"), 0600) },
+            want: []fsnotify.Op{fsnotify.Chmod},
+        },
+        {
+            name: "write and chmod",
+            setup: func(dir string) error { return ioutil.WriteFile(filepath.Join(dir, "f"), []byte("a"), 0644) },
+            change: func(dir string) error {
+                if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("abc"), 0644); err != nil { return err }
+                return os.Chmod(filepath.Join(dir, "f"), 0600)
+            },
+            want: []fsnotify.Op{fsnotify.Write, fsnotify.Chmod},
+        },
+        {
+            name: "replaced by a rename",
+            setup: func(dir string) error {
+                if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("a"), 0644); err != nil { return err }
+                return ioutil.WriteFile(filepath.Join(dir, "g"), []byte("a"), 0644)
+            },
+            change: func(dir string) error { return os.Rename(filepath.Join(dir, "g"), filepath.Join(dir, "f")) },
+            want: []fsnotify.Op{fsnotify.Remove, fsnotify.Create, fsnotify.Remove},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir, err := ioutil.TempDir("", "polling")
+            if err != nil { t.Fatal(err) }
+            defer os.RemoveAll(dir)
+            if tt.setup != nil {
+                if err := tt.setup(dir); err != nil { t.Fatal(err) }
+            }
+
+            // the ticker never fires, scan is called directly
+            w, err := NewWatcher(time.Hour, 0)
+            if err != nil { t.Fatal(err) }
+            defer w.Close()
+            if err := w.Add(dir); err != nil { t.Fatal(err) }
+
+            // the mtime may be too coarse to notice a change made straight away
+            time.Sleep(10 * time.Millisecond)
+            if err := tt.change(dir); err != nil { t.Fatal(err) }
+            events, err := w.scan()
+            if err != nil { t.Fatal(err) }
+
+            var got []fsnotify.Op
+            for _, e := range events {
+                if e.Name == dir { continue }
+                got = append(got, e.Op)
+            }
+            if reflect.DeepEqual(got, tt.want) == false {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+
+            // nothing more to report on the next scan
+            events, err = w.scan()
+            if err != nil { t.Fatal(err) }
+            if len(events) != 0 {
+                t.Errorf("got %v on a second scan", events)
+            }
+        })
+    }
+}
+
+func TestScanMaxFiles(t *testing.T) {
+    dir, err := ioutil.TempDir("", "polling")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(dir)
+    for _, sub := range []string{"a", "b"} {
+        if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil { t.Fatal(err) }
+    }
+
+    w, err := NewWatcher(time.Hour, 1)
+    if err != nil { t.Fatal(err) }
+    defer w.Close()
+    for _, sub := range []string{"a", "b"} {
+        if err := w.Add(filepath.Join(dir, sub)); err != nil { t.Fatal(err) }
+    }
+    if _, err := w.scan(); err == nil {
+        t.Errorf("expected a warning about the file limit")
+    }
+    if _, err := w.scan(); err != nil {
+        t.Errorf("warned twice: %v", err)
+    }
+}
@@ -0,0 +1,13 @@
+package polling
+
+import (
+    "os"
+    "syscall"
+    "time"
+)
+
+func inodeAndCtime(info os.FileInfo) (uint64, time.Time) {
+    st, ok := info.Sys().(*syscall.Stat_t)
+    if ok == false { return 0, time.Time{} }
+    return uint64(st.Ino), time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
+}
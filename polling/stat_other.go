@@ -0,0 +1,13 @@
+// +build !linux,!darwin
+
+package polling
+
+import (
+    "os"
+    "time"
+)
+
+// without an inode or ctime, only size, mode and mtime are compared
+func inodeAndCtime(info os.FileInfo) (uint64, time.Time) {
+    return 0, time.Time{}
+}
@@ -0,0 +1,144 @@
+package preflight
+
+import (
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+)
+
+const limitsDir = "/proc/sys/fs/inotify/"
+
+// Limits are the kernel's inotify limits, each of them per user.
+type Limits struct {
+    MaxUserWatches int
+    MaxUserInstances int
+    MaxQueuedEvents int
+}
+
+// Usage is how much of the limits a user's processes are already using.
+type Usage struct {
+    Watches int
+    Instances int
+}
+
+// Report compares what a capture needs with what is left.
+type Report struct {
+    Directories int
+    Limits Limits
+    Others Usage
+}
+
+// Check reads the limits and what other processes of this user hold, failing
+// where there is no /proc to read them from.
+func Check(directories int) (Report, error) {
+    limits, err := ReadLimits()
+    if err != nil { return Report{}, err }
+    return Report{
+        Directories: directories,
+        Limits: limits,
+        Others: OtherUsage(os.Getuid(), os.Getpid()),
+    }, nil
+}
+
+func ReadLimits() (Limits, error) {
+    var l Limits
+    var err error
+    l.MaxUserWatches, err = readInt(limitsDir + "max_user_watches")
+    if err != nil { return l, err }
+    l.MaxUserInstances, err = readInt(limitsDir + "max_user_instances")
+    if err != nil { return l, err }
+    l.MaxQueuedEvents, err = readInt(limitsDir + "max_queued_events")
+    return l, err
+}
+
+func readInt(path string) (int, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil { return 0, err }
+    return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// OtherUsage estimates the inotify instances and watches held by the
+// processes of a user, other than self, from /proc/*/fdinfo. Processes that
+// can't be read, usually those of other users when not root, are skipped.
+func OtherUsage(uid int, self int) Usage {
+    var u Usage
+    procs, err := ioutil.ReadDir("/proc")
+    if err != nil { return u }
+    for _, proc := range procs {
+        pid, err := strconv.Atoi(proc.Name())
+        if err != nil || pid == self { continue }
+        if processUID(pid) != uid { continue }
+
+        dir := "/proc/" + proc.Name()
+        fds, err := ioutil.ReadDir(dir + "/fd")
+        if err != nil { continue }
+        for _, fd := range fds {
+            target, err := os.Readlink(dir + "/fd/" + fd.Name())
+            if err != nil || target != "anon_inode:inotify" { continue }
+            u.Instances++
+
+            // every watch of the instance has its own line
+            info, err := ioutil.ReadFile(dir + "/fdinfo/" + fd.Name())
+            if err != nil { continue }
+            u.Watches += strings.Count(string(info), "inotify wd:")
+        }
+    }
+    return u
+}
+
+// processUID is the real uid of a process, which the limits are counted against.
+func processUID(pid int) int {
+    status, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+    if err != nil { return -1 }
+    for _, line := range strings.Split(string(status), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) > 1 && fields[0] == "Uid:" {
+            uid, err := strconv.Atoi(fields[1])
+            if err != nil { return -1 }
+            return uid
+        }
+    }
+    return -1
+}
+
+// WatchShortfall is how many more watches are needed than are available.
+func (r Report) WatchShortfall() int {
+    short := r.Directories + r.Others.Watches - r.Limits.MaxUserWatches
+    if short < 0 { return 0 }
+    return short
+}
+
+// InstanceShortfall is one when every instance is already taken, since a capture needs one.
+func (r Report) InstanceShortfall() int {
+    short := r.Others.Instances + 1 - r.Limits.MaxUserInstances
+    if short < 0 { return 0 }
+    return short
+}
+
+func (r Report) OK() bool {
+    return r.WatchShortfall() == 0 && r.InstanceShortfall() == 0
+}
+
+// Print describes the report, with the sysctl commands that would fix any shortfall.
+func (r Report) Print(out io.Writer) {
+    fmt.Fprintf(out, "Preflight: %d directories to watch, other processes hold %d of %d watches and %d of %d instances, up to %d queued events.\n",
+        r.Directories, r.Others.Watches, r.Limits.MaxUserWatches, r.Others.Instances, r.Limits.MaxUserInstances, r.Limits.MaxQueuedEvents)
+
+    if short := r.WatchShortfall(); short > 0 {
+        fmt.Fprintf(out, "Short by %d inotify watches, so some directories won't be watched. To raise the limit:\n", short)
+        printSysctl(out, "max_user_watches", r.Limits.MaxUserWatches + short)
+    }
+    if short := r.InstanceShortfall(); short > 0 {
+        fmt.Fprintf(out, "Every inotify instance is in use, so watching will fail. To raise the limit:\n")
+        printSysctl(out, "max_user_instances", r.Limits.MaxUserInstances + short)
+    }
+}
+
+func printSysctl(out io.Writer, name string, value int) {
+    fmt.Fprintf(out, "    sudo sysctl fs.inotify.%s=%d\n", name, value)
+    fmt.Fprintf(out, "and to keep it after a reboot:\n")
+    fmt.Fprintf(out, "    echo fs.inotify.%s=%d | sudo tee -a /etc/sysctl.d/90-inotify-spy.conf\n", name, value)
+}
@@ -0,0 +1,51 @@
+package procinfo
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Process is what could be found out about the process behind an event.
+type Process struct {
+    Pid int
+    Comm string
+    Exe string
+}
+
+// String names the process as comm[pid], or just the pid if it had already
+// exited before it could be looked up.
+func (p Process) String() string {
+    if p.Comm == "" { return fmt.Sprintf("[%d]", p.Pid) }
+    return fmt.Sprintf("%s[%d]", p.Comm, p.Pid)
+}
+
+var (
+    lock sync.Mutex
+    cache = make(map[int]Process)
+)
+
+// Lookup resolves a pid from /proc. Results are cached, since short lived
+// processes are often gone by the time their later events are handled, so a
+// pid reused within one capture keeps the name of its first process.
+func Lookup(pid int) Process {
+    lock.Lock()
+    defer lock.Unlock()
+
+    p, ok := cache[pid]
+    if ok { return p }
+
+    p = Process{Pid: pid}
+    dir := "/proc/" + strconv.Itoa(pid)
+    comm, err := ioutil.ReadFile(dir + "/comm")
+    if err == nil { p.Comm = strings.TrimSpace(string(comm)) }
+    exe, err := os.Readlink(dir + "/exe")
+    if err == nil { p.Exe = exe }
+
+    // don't remember processes that vanished before we could see them
+    if p.Comm != "" { cache[pid] = p }
+    return p
+}
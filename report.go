@@ -0,0 +1,64 @@
+package main
+
+import (
+    "io"
+
+    "github.com/AstromechZA/inotify-spy/eventbox"
+    "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/moves"
+    "github.com/AstromechZA/inotify-spy/session"
+)
+
+// replaySession feeds a recorded session back through the box, applying the
+// record mask and ignore prefixes as if they had been given during capture.
+// It returns the capture details from the session header.
+func replaySession(path string, box *eventbox.EventBox, recordMask uint, ignorePrefixes *[]string) (session.Info, error) {
+    reader, err := session.OpenReader(path)
+    if err != nil { return session.Info{}, err }
+    defer reader.Close()
+
+    pairer := moves.NewPairer(moves.DefaultWindow)
+    recordMove := func(m fileevents.Move) {
+        // an ignored side of a move is treated as outside the tree
+        if m.From != "" && mustIgnorePath(m.From, ignorePrefixes) { m.From = "" }
+        if m.To != "" && mustIgnorePath(m.To, ignorePrefixes) { m.To = "" }
+        if m.From == "" && m.To == "" { return }
+        if recordMask & uint(m.Op()) == uint(m.Op()) {
+            box.AddMove(m)
+        }
+    }
+
+    for {
+        entry, err := reader.Next()
+        if err == io.EOF { break }
+        if err != nil { return session.Info{}, err }
+
+        event := entry.Event
+        for _, m := range pairer.Expire(event.Time) {
+            recordMove(m)
+        }
+
+        if entry.Overflow {
+            box.AddOverflow()
+            continue
+        }
+
+        if event.Cookie != 0 {
+            m, complete := pairer.Add(&event, event.Time)
+            if complete {
+                recordMove(m)
+            }
+            continue
+        }
+
+        if mustIgnorePath(event.Name, ignorePrefixes) { continue }
+        if recordMask & uint(event.Op) == uint(event.Op) {
+            box.Add(&event)
+        }
+    }
+
+    for _, m := range pairer.Flush() {
+        recordMove(m)
+    }
+    return reader.Info(), nil
+}
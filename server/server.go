@@ -0,0 +1,207 @@
+package server
+
+import (
+    "bytes"
+    "fmt"
+    "html/template"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/eventbox"
+    "github.com/AstromechZA/inotify-spy/fileevents"
+    "github.com/AstromechZA/inotify-spy/live"
+    "github.com/AstromechZA/inotify-spy/summary"
+)
+
+// topFiles is how many files the html page shows
+const topFiles = 50
+
+// clientBuffer is how many events a slow event stream client can fall behind
+// before events are dropped for it
+const clientBuffer = 256
+
+// Server exposes a capture in progress over http:
+//
+//     /             a page showing the top files, refreshed every few seconds
+//     /api/summary  the box as json, like -export-json; ?sort=name sorts by path
+//     /api/events   a server-sent events stream of recorded events
+//
+// It implements live.Printer so that it can be fed the recorded events.
+type Server struct {
+    box *eventbox.EventBox
+    mux *http.ServeMux
+
+    capture func() summary.Capture
+
+    mu sync.Mutex
+    clients map[chan []byte]bool
+}
+
+// New serves the box, reporting the capture details capture returns at the
+// time of each request.
+func New(box *eventbox.EventBox, capture func() summary.Capture) *Server {
+    s := &Server{
+        box: box,
+        mux: http.NewServeMux(),
+        capture: capture,
+        clients: make(map[chan []byte]bool),
+    }
+    s.mux.HandleFunc("/", s.handleIndex)
+    s.mux.HandleFunc("/api/summary", s.handleSummary)
+    s.mux.HandleFunc("/api/events", s.handleEvents)
+    return s
+}
+
+// Handle serves another endpoint alongside the built in ones.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+    s.mux.Handle(pattern, handler)
+}
+
+// Listen binds the address and serves in the background.
+func (s *Server) Listen(addr string) error {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil { return err }
+
+    go http.Serve(listener, s.mux)
+    return nil
+}
+
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+    content, err := summary.JSON(s.box, s.capture(), r.URL.Query().Get("sort") == "name")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(content)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if ok == false {
+        http.Error(w, "streaming not supported", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    flusher.Flush()
+
+    client := make(chan []byte, clientBuffer)
+    s.mu.Lock()
+    s.clients[client] = true
+    s.mu.Unlock()
+    defer func() {
+        s.mu.Lock()
+        delete(s.clients, client)
+        s.mu.Unlock()
+    }()
+
+    for {
+        select {
+        case data := <- client:
+            fmt.Fprintf(w, "data: %s\n\n", bytes.TrimSpace(data))
+            flusher.Flush()
+        case <- r.Context().Done():
+            return
+        }
+    }
+}
+
+// broadcast renders an event with the json live printer and hands it to
+// every connected client, dropping it for clients that are too far behind.
+func (s *Server) broadcast(print func(p live.Printer)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.clients) == 0 { return }
+
+    var buf bytes.Buffer
+    print(live.NewJSONPrinter(&buf, s.capture().Target))
+    for client := range s.clients {
+        select {
+        case client <- buf.Bytes():
+        default:
+        }
+    }
+}
+
+func (s *Server) Event(e *fsnotify.Event, count int) {
+    s.broadcast(func(p live.Printer) { p.Event(e, count) })
+}
+
+func (s *Server) Move(m fileevents.Move) {
+    s.broadcast(func(p live.Printer) { p.Move(m) })
+}
+
+func (s *Server) Overflow(t time.Time) {
+    s.broadcast(func(p live.Printer) { p.Overflow(t) })
+}
+
+type indexRow struct {
+    Name string
+    Total int
+    Counts []int
+}
+
+type indexData struct {
+    Capture summary.Capture
+    Overflows int
+    Ops []string
+    Rows []indexRow
+    Files int
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="2">
+<title>inotify-spy: {{.Capture.Target}}</title>
+<style>
+body { font-family: monospace; }
+td, th { padding: 0 0.5em; text-align: right; }
+td:last-child, th:last-child { text-align: left; }
+.warning { color: #b00; }
+</style>
+</head>
+<body>
+<h1>inotify-spy: {{.Capture.Target}}</h1>
+{{if .Overflows}}<p class="warning">WARNING: the inotify queue overflowed {{.Overflows}} times, the counts are incomplete.</p>{{end}}
+<p>Top {{len .Rows}} of {{.Files}} files. <a href="/api/summary">json</a> <a href="/api/events">events</a></p>
+<table>
+<tr><th>Total</th>{{range .Ops}}<th>{{.}}</th>{{end}}<th>Path</th></tr>
+{{range .Rows}}<tr><td>{{.Total}}</td>{{range .Counts}}<td>{{.}}</td>{{end}}<td>{{.Name}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/" {
+        http.NotFound(w, r)
+        return
+    }
+
+    box := s.box.Snapshot()
+    capture := s.capture()
+    ops := summary.RecordedOps(capture.RecordMask)
+    fevents := summary.SortedFiles(box, false)
+
+    data := indexData{Capture: capture, Overflows: box.Overflows, Files: len(fevents)}
+    for _, op := range ops {
+        data.Ops = append(data.Ops, fileevents.OpName(op))
+    }
+    if len(fevents) > topFiles { fevents = fevents[:topFiles] }
+    for _, v := range fevents {
+        row := indexRow{Name: v.Name, Total: v.Total}
+        for _, op := range ops {
+            row.Counts = append(row.Counts, v.Events[op])
+        }
+        data.Rows = append(data.Rows, row)
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    indexTemplate.Execute(w, data)
+}
@@ -0,0 +1,211 @@
+package session
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+// header is the first line of every session file
+const header = "# inotify-spy session v1"
+
+// Info describes the capture a session was recorded from.
+type Info struct {
+    Target string
+    Recursive bool
+    // RecordMask holds the ops that were recorded, the others never appear
+    RecordMask uint
+}
+
+// A session file is a line based log of every recorded event. The header is
+// followed by the capture details
+//
+//     # target <quoted path>
+//     # recursive <true|false>
+//     # record-mask <mask>
+//
+// then event lines look like
+//
+//     E <unix nanos> <op> <is-dir 0|1> <cookie> <quoted path>
+//
+// and a queue overflow is recorded as
+//
+//     O <unix nanos>
+//
+// Appending to an existing file adds another header and capture details.
+type Writer struct {
+    file *os.File
+    buf *bufio.Writer
+    err error
+}
+
+// NewWriter opens the session file at path for appending, creating it if needed.
+func NewWriter(path string, info Info) (*Writer, error) {
+    f, err := os.OpenFile(path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil { return nil, err }
+    w := &Writer{
+        file: f,
+        buf: bufio.NewWriter(f),
+    }
+    w.writeLine(header)
+    w.writeLine("# target " + strconv.Quote(info.Target))
+    w.writeLine("# recursive " + strconv.FormatBool(info.Recursive))
+    w.writeLine("# record-mask " + strconv.FormatUint(uint64(info.RecordMask), 10))
+    return w, nil
+}
+
+func (w *Writer) writeLine(line string) {
+    if w.err != nil { return }
+    _, w.err = w.buf.WriteString(line + "\n")
+}
+
+// WriteEvent appends an event to the session.
+func (w *Writer) WriteEvent(e *fsnotify.Event) {
+    isDir := 0
+    if e.IsDir { isDir = 1 }
+    w.writeLine(fmt.Sprintf("E %d %d %d %d %s", e.Time.UnixNano(), uint32(e.Op), isDir, e.Cookie, strconv.Quote(e.Name)))
+}
+
+// WriteOverflow appends a queue overflow marker to the session.
+func (w *Writer) WriteOverflow(t time.Time) {
+    w.writeLine(fmt.Sprintf("O %d", t.UnixNano()))
+}
+
+// Close flushes and closes the session file, returning the first error hit
+// while writing.
+func (w *Writer) Close() error {
+    if w.err == nil {
+        w.err = w.buf.Flush()
+    }
+    cerr := w.file.Close()
+    if w.err != nil { return w.err }
+    return cerr
+}
+
+// Entry is one line of a session file: either an event or a queue overflow,
+// in which case only Event.Time is set.
+type Entry struct {
+    Event fsnotify.Event
+    Overflow bool
+}
+
+// Reader reads the entries of a session file in order.
+type Reader struct {
+    path string
+    file *os.File
+    scanner *bufio.Scanner
+    line int
+
+    info Info
+    // partMask is the record mask of the session being read, every op
+    // when its header doesn't say
+    partMask uint
+    parts int
+}
+
+func OpenReader(path string) (*Reader, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    scanner := bufio.NewScanner(f)
+    // paths can be long, allow lines well beyond the default 64k
+    scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+    return &Reader{
+        path: path,
+        file: f,
+        scanner: scanner,
+    }, nil
+}
+
+// Next returns the next entry, or io.EOF once the file is exhausted.
+func (r *Reader) Next() (*Entry, error) {
+    for r.scanner.Scan() {
+        r.line++
+        line := r.scanner.Text()
+        if strings.HasPrefix(line, "#") {
+            r.parseComment(line)
+            continue
+        }
+        if line == "" { continue }
+
+        entry, err := parseLine(line)
+        if err != nil {
+            return nil, fmt.Errorf("%s:%d: %s", r.path, r.line, err.Error())
+        }
+        return entry, nil
+    }
+    if err := r.scanner.Err(); err != nil { return nil, err }
+    return nil, io.EOF
+}
+
+// Info describes the capture from the header lines read so far. A file
+// holding several appended sessions gives the details of the first, with
+// only the ops recorded in all of them.
+func (r *Reader) Info() Info {
+    info := r.info
+    if r.parts == 0 {
+        info.RecordMask = fileevents.AllOpsMask()
+        return info
+    }
+    info.RecordMask &= r.partMask
+    return info
+}
+
+func (r *Reader) parseComment(line string) {
+    switch {
+    case strings.HasPrefix(line, header):
+        if r.parts == 0 {
+            r.info.RecordMask = fileevents.AllOpsMask()
+        } else {
+            r.info.RecordMask &= r.partMask
+        }
+        r.parts++
+        r.partMask = fileevents.AllOpsMask()
+    case strings.HasPrefix(line, "# target "):
+        target, err := strconv.Unquote(strings.TrimPrefix(line, "# target "))
+        if err == nil && r.info.Target == "" { r.info.Target = target }
+    case strings.HasPrefix(line, "# recursive "):
+        // the first session decides, like the target
+        if r.parts == 1 { r.info.Recursive = strings.TrimPrefix(line, "# recursive ") == "true" }
+    case strings.HasPrefix(line, "# record-mask "):
+        mask, err := strconv.ParseUint(strings.TrimPrefix(line, "# record-mask "), 10, 32)
+        if err == nil { r.partMask = uint(mask) }
+    }
+}
+
+func (r *Reader) Close() error {
+    return r.file.Close()
+}
+
+func parseLine(line string) (*Entry, error) {
+    parts := strings.SplitN(line, " ", 6)
+    switch {
+    case parts[0] == "O" && len(parts) == 2:
+        nanos, err := strconv.ParseInt(parts[1], 10, 64)
+        if err != nil { return nil, err }
+        return &Entry{Event: fsnotify.Event{Time: time.Unix(0, nanos)}, Overflow: true}, nil
+    case parts[0] == "E" && len(parts) == 6:
+        nanos, err := strconv.ParseInt(parts[1], 10, 64)
+        if err != nil { return nil, err }
+        op, err := strconv.ParseUint(parts[2], 10, 32)
+        if err != nil { return nil, err }
+        cookie, err := strconv.ParseUint(parts[4], 10, 32)
+        if err != nil { return nil, err }
+        name, err := strconv.Unquote(parts[5])
+        if err != nil { return nil, err }
+        return &Entry{Event: fsnotify.Event{
+            Name: name,
+            Op: fsnotify.Op(op),
+            Cookie: uint32(cookie),
+            Time: time.Unix(0, nanos),
+            IsDir: parts[3] == "1",
+        }}, nil
+    }
+    return nil, fmt.Errorf("unrecognised session line %q", line)
+}
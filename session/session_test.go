@@ -0,0 +1,136 @@
+package session
+
+import (
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "github.com/fsnotify/fsnotify"
+
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+func readAll(t *testing.T, path string) ([]*Entry, Info) {
+    r, err := OpenReader(path)
+    if err != nil { t.Fatal(err) }
+    defer r.Close()
+    var entries []*Entry
+    for {
+        entry, err := r.Next()
+        if err == io.EOF { break }
+        if err != nil { t.Fatal(err) }
+        entries = append(entries, entry)
+    }
+    return entries, r.Info()
+}
+
+func TestRoundTrip(t *testing.T) {
+    at := time.Unix(1700000000, 123456789)
+    tests := []struct {
+        name string
+        event fsnotify.Event
+    }{
+        {"plain", fsnotify.Event{Name: "/tmp/a", Op: fsnotify.Write, Time: at}},
+        {"directory", fsnotify.Event{Name: "/tmp/dir", Op: fsnotify.Create, Time: at, IsDir: true}},
+        {"move half", fsnotify.Event{Name: "/tmp/b", Op: fsnotify.Rename, Time: at, Cookie: 42}},
+        {"spaces", fsnotify.Event{Name: "/tmp/with space/and  more", Op: fsnotify.Open, Time: at}},
+        {"quotes and escapes", fsnotify.Event{Name: "/tmp/\"quoted\"\\back\tslash", Op: fsnotify.CloseWrite, Time: at}},
+        {"newline", fsnotify.Event{Name: "/tmp/line\nbreak", Op: fsnotify.Remove, Time: at}},
+        {"unicode", fsnotify.Event{Name: "/tmp/ünïcødé ✓", Op: fsnotify.Access, Time: at}},
+    }
+
+    dir, err := ioutil.TempDir("", "session")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "test.session")
+
+    w, err := NewWriter(path, Info{Target: "/tmp", Recursive: true, RecordMask: fileevents.AllOpsMask()})
+    if err != nil { t.Fatal(err) }
+    for i := range tests {
+        w.WriteEvent(&tests[i].event)
+    }
+    w.WriteOverflow(at)
+    if err := w.Close(); err != nil { t.Fatal(err) }
+
+    entries, _ := readAll(t, path)
+    if len(entries) != len(tests) + 1 {
+        t.Fatalf("read %d entries, want %d", len(entries), len(tests) + 1)
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := entries[i]
+            if got.Overflow { t.Fatalf("got an overflow") }
+            if got.Event.Name != tt.event.Name || got.Event.Op != tt.event.Op || got.Event.Cookie != tt.event.Cookie ||
+                got.Event.IsDir != tt.event.IsDir || got.Event.Time.Equal(tt.event.Time) == false {
+                t.Errorf("got %+v, want %+v", got.Event, tt.event)
+            }
+        })
+    }
+    last := entries[len(entries) - 1]
+    if last.Overflow == false || last.Event.Time.Equal(at) == false {
+        t.Errorf("got %+v, want an overflow at %v", last, at)
+    }
+}
+
+func TestInfo(t *testing.T) {
+    dir, err := ioutil.TempDir("", "session")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(dir)
+
+    tests := []struct {
+        name string
+        content string
+        want Info
+    }{
+        {
+            name: "header without details",
+            content: header + "\n",
+            want: Info{RecordMask: fileevents.AllOpsMask()},
+        },
+        {
+            name: "details",
+            content: header + "\n# target \"/src/my dir\"\n# recursive true\n# record-mask 3\n",
+            want: Info{Target: "/src/my dir", Recursive: true, RecordMask: 3},
+        },
+        {
+            name: "appended sessions keep the first target and the common ops",
+            content: header + "\n# target \"/a\"\n# recursive true\n# record-mask 7\n" +
+                header + "\n# target \"/b\"\n# recursive false\n# record-mask 6\n",
+            want: Info{Target: "/a", Recursive: true, RecordMask: 6},
+        },
+        {
+            name: "an appended session without details recorded every op",
+            content: header + "\n" + header + "\n# record-mask 5\n",
+            want: Info{RecordMask: 5},
+        },
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(dir, string(rune('a' + i)) + ".session")
+            if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil { t.Fatal(err) }
+            _, got := readAll(t, path)
+            if got != tt.want {
+                t.Errorf("got %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestWriterHeader(t *testing.T) {
+    dir, err := ioutil.TempDir("", "session")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "test.session")
+
+    want := Info{Target: "/tmp/\"odd\" name", Recursive: true, RecordMask: uint(fsnotify.Create | fsnotify.Write)}
+    w, err := NewWriter(path, want)
+    if err != nil { t.Fatal(err) }
+    if err := w.Close(); err != nil { t.Fatal(err) }
+
+    _, got := readAll(t, path)
+    if got != want {
+        t.Errorf("got %+v, want %+v", got, want)
+    }
+}
@@ -0,0 +1,26 @@
+// +build !windows
+
+package main
+
+import (
+    "os"
+    "syscall"
+
+    "golang.org/x/sys/unix"
+)
+
+// snapshotSignal prints and exports the summary so far without stopping, and
+// resetSignal clears the counts, together they can bracket phases of a capture.
+var snapshotSignal os.Signal = syscall.SIGUSR1
+var resetSignal os.Signal = syscall.SIGUSR2
+
+// reloadSignal reloads the ignore prefixes file and re-evaluates the watches
+var reloadSignal os.Signal = syscall.SIGHUP
+
+// inForeground checks whether inotify-spy, and so a command it started, is in
+// the foreground process group of the terminal, where Ctrl-C reaches both.
+func inForeground() bool {
+    pgrp, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+    if err != nil { return false }
+    return pgrp == unix.Getpgrp()
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+    "os"
+)
+
+// windows has no user signals, so snapshots, resets and reloads are not available
+var snapshotSignal os.Signal
+var resetSignal os.Signal
+var reloadSignal os.Signal
+
+// inForeground is always true, the console sends Ctrl-C to every process attached to it
+func inForeground() bool { return true }
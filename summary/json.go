@@ -0,0 +1,147 @@
+package summary
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "time"
+
+    "github.com/AstromechZA/inotify-spy/eventbox"
+    "github.com/AstromechZA/inotify-spy/fileevents"
+)
+
+type jsonCapture struct {
+    Type string `json:"type,omitempty"`
+    Target string `json:"target"`
+    Recursive bool `json:"recursive"`
+    RecordMask uint `json:"record_mask"`
+    RecordedOps []string `json:"recorded_ops"`
+    Start *time.Time `json:"start,omitempty"`
+    Stop *time.Time `json:"stop,omitempty"`
+    Watched int `json:"watched_directories"`
+    NotWatched int `json:"not_watched_directories"`
+    Overflows int `json:"overflows"`
+}
+
+type jsonFile struct {
+    Type string `json:"type,omitempty"`
+    Path string `json:"path"`
+    Total int `json:"total"`
+    Events map[string]int `json:"events"`
+    FirstSeen time.Time `json:"first_seen"`
+    LastSeen time.Time `json:"last_seen"`
+    Processes map[string]int `json:"processes,omitempty"`
+}
+
+type jsonMove struct {
+    Type string `json:"type,omitempty"`
+    From string `json:"from,omitempty"`
+    To string `json:"to,omitempty"`
+    Time time.Time `json:"time"`
+}
+
+type jsonSummary struct {
+    jsonCapture
+    Files []jsonFile `json:"files"`
+    Moves []jsonMove `json:"moves"`
+}
+
+// optionalTime leaves unknown times out of the export
+func optionalTime(t time.Time) *time.Time {
+    if t.IsZero() { return nil }
+    return &t
+}
+
+func toJSONCapture(box *eventbox.EventBox, capture Capture) jsonCapture {
+    jc := jsonCapture{
+        Target: capture.Target,
+        Recursive: capture.Recursive,
+        RecordMask: capture.RecordMask,
+        RecordedOps: []string{},
+        Start: optionalTime(capture.Start),
+        Stop: optionalTime(capture.Stop),
+        Watched: capture.Watched,
+        NotWatched: capture.NotWatched,
+        Overflows: box.Overflows,
+    }
+    for _, op := range RecordedOps(capture.RecordMask) {
+        jc.RecordedOps = append(jc.RecordedOps, fileevents.OpName(op))
+    }
+    return jc
+}
+
+func toJSONFile(v fileevents.FileWithEvents, recordMask uint) jsonFile {
+    jf := jsonFile{
+        Path: v.Name,
+        Total: v.Total,
+        Events: make(map[string]int),
+        FirstSeen: v.FirstSeen,
+        LastSeen: v.LastSeen,
+        Processes: v.Processes,
+    }
+    for _, op := range RecordedOps(recordMask) {
+        jf.Events[fileevents.OpName(op)] = v.Events[op]
+    }
+    return jf
+}
+
+func toJSONMove(m fileevents.Move) jsonMove {
+    return jsonMove{From: m.From, To: m.To, Time: m.Time}
+}
+
+// writeJSON exports the summary as a single document with the capture
+// metadata and the files in the same order as the table.
+func writeJSON(path string, box *eventbox.EventBox, capture Capture, fevents []fileevents.FileWithEvents) error {
+    content, err := json.MarshalIndent(toJSONSummary(box, capture, fevents), "", "  ")
+    if err != nil { return err }
+    return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// JSON renders the current contents of the box in the same form as
+// -export-json.
+func JSON(box *eventbox.EventBox, capture Capture, sortByName bool) ([]byte, error) {
+    box = box.Snapshot()
+    return json.Marshal(toJSONSummary(box, capture, SortedFiles(box, sortByName)))
+}
+
+func toJSONSummary(box *eventbox.EventBox, capture Capture, fevents []fileevents.FileWithEvents) jsonSummary {
+    doc := jsonSummary{
+        jsonCapture: toJSONCapture(box, capture),
+        Files: []jsonFile{},
+        Moves: []jsonMove{},
+    }
+    for _, v := range fevents {
+        doc.Files = append(doc.Files, toJSONFile(v, capture.RecordMask))
+    }
+    for _, m := range box.Moves {
+        doc.Moves = append(doc.Moves, toJSONMove(m))
+    }
+    return doc
+}
+
+// writeNDJSON exports the summary as one json object per line. The first line
+// describes the capture and the rest are files then moves, told apart by
+// their "type" field.
+func writeNDJSON(path string, box *eventbox.EventBox, capture Capture, fevents []fileevents.FileWithEvents) error {
+    var buf bytes.Buffer
+    encoder := json.NewEncoder(&buf)
+
+    jc := toJSONCapture(box, capture)
+    jc.Type = "capture"
+    err := encoder.Encode(jc)
+    if err != nil { return err }
+
+    for _, v := range fevents {
+        jf := toJSONFile(v, capture.RecordMask)
+        jf.Type = "file"
+        err = encoder.Encode(jf)
+        if err != nil { return err }
+    }
+    for _, m := range box.Moves {
+        jm := toJSONMove(m)
+        jm.Type = "move"
+        err = encoder.Encode(jm)
+        if err != nil { return err }
+    }
+    return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -4,7 +4,12 @@ import (
     "fmt"
     "strconv"
     "sort"
+    "io"
     "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
 
     "github.com/fsnotify/fsnotify"
 
@@ -12,41 +17,71 @@ import (
     "github.com/AstromechZA/inotify-spy/eventbox"
 )
 
-var opNameLookup = map[fsnotify.Op]string {
-    fsnotify.Create: "Create",                  // 1
-    fsnotify.Write: "Write",                    // 2
-    fsnotify.Remove: "Remove",                  // 4
-    fsnotify.Rename: "Rename",                  // 8
-    fsnotify.Chmod: "Chmod",                    // 16
-    fsnotify.Open: "Open",                      // 32
+// RecordedOps returns the operations included in the record mask, in column order.
+func RecordedOps(recordMask uint) []fsnotify.Op {
+    var ops []fsnotify.Op
+    for _, op := range fileevents.Ops {
+        if recordMask & uint(op) == uint(op) {
+            ops = append(ops, op)
+        }
+    }
+    return ops
 }
 
-func DoSummary(box *eventbox.EventBox, recordMask uint, sortByName bool, exportCSV string) error {
+// ColumnWidth leaves at least one space after the op name
+func ColumnWidth(op fsnotify.Op) int {
+    width := len(fileevents.OpName(op)) + 1
+    if width < 7 { return 7 }
+    return width
+}
 
-    fmt.Println()
+// timeColumnFormat is used for the first-seen and last-seen table columns
+const timeColumnFormat = "2006-01-02 15:04:05.000"
 
-    strColumn := "%-7s"
-    numColumn := "%-7d"
-    if recordMask & uint(fsnotify.Create) == uint(fsnotify.Create) {
-        fmt.Printf(strColumn, "Create")
-    }
-    if recordMask & uint(fsnotify.Write) == uint(fsnotify.Write) {
-        fmt.Printf(strColumn, "Write")
-    }
-    if recordMask & uint(fsnotify.Remove) == uint(fsnotify.Remove) {
-        fmt.Printf(strColumn, "Remove")
-    }
-    if recordMask & uint(fsnotify.Rename) == uint(fsnotify.Rename) {
-        fmt.Printf(strColumn, "Rename")
-    }
-    if recordMask & uint(fsnotify.Chmod) == uint(fsnotify.Chmod) {
-        fmt.Printf(strColumn, "Chmod")
-    }
-    if recordMask & uint(fsnotify.Open) == uint(fsnotify.Open) {
-        fmt.Printf(strColumn, "Open")
-    }
-    fmt.Println("Path")
+func overflowWarning(overflows int) string {
+    return fmt.Sprintf("WARNING: the inotify queue overflowed %d times, events were dropped and the counts below are incomplete.", overflows)
+}
+
+// Options controls how DoSummary sorts, prints and exports the summary.
+type Options struct {
+    SortByName bool
+    ShowTimes bool
+    ExportCSV string
+    ExportJSON string
+    ExportNDJSON string
 
+    // Output is where the table is printed, stdout if nil.
+    Output io.Writer
+}
+
+// Suffixed returns a copy of the options with the suffix inserted before the
+// extension of each export path, so that repeated exports don't overwrite each other.
+func (o Options) Suffixed(suffix string) Options {
+    o.ExportCSV = suffixPath(o.ExportCSV, suffix)
+    o.ExportJSON = suffixPath(o.ExportJSON, suffix)
+    o.ExportNDJSON = suffixPath(o.ExportNDJSON, suffix)
+    return o
+}
+
+func suffixPath(path string, suffix string) string {
+    if path == "" { return path }
+    ext := filepath.Ext(path)
+    return strings.TrimSuffix(path, ext) + suffix + ext
+}
+
+// Capture describes where the summarised events came from.
+type Capture struct {
+    Target string
+    Recursive bool
+    RecordMask uint
+    Start time.Time
+    Stop time.Time
+    Watched int
+    NotWatched int
+}
+
+// SortedFiles lists the files in the box by most events, or by name.
+func SortedFiles(box *eventbox.EventBox, sortByName bool) []fileevents.FileWithEvents {
     var fevents []fileevents.FileWithEvents
     for _, v := range (*box).Data {
         fevents = append(fevents, v)
@@ -56,77 +91,112 @@ func DoSummary(box *eventbox.EventBox, recordMask uint, sortByName bool, exportC
     } else {
         sort.Sort(fileevents.ByEventTotal(fevents))
     }
+    return fevents
+}
 
-    for _, v := range fevents {
+// sortedProcesses lists the processes behind a file by most events, then by name.
+func sortedProcesses(processes map[string]int) []string {
+    var names []string
+    for p := range processes {
+        names = append(names, p)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        if processes[names[i]] != processes[names[j]] { return processes[names[i]] > processes[names[j]] }
+        return names[i] < names[j]
+    })
+    return names
+}
 
-        if recordMask & uint(fsnotify.Create) == uint(fsnotify.Create) {
-            fmt.Printf(numColumn, v.Events[fsnotify.Create])
-        }
-        if recordMask & uint(fsnotify.Write) == uint(fsnotify.Write) {
-            fmt.Printf(numColumn, v.Events[fsnotify.Write])
-        }
-        if recordMask & uint(fsnotify.Remove) == uint(fsnotify.Remove) {
-            fmt.Printf(numColumn, v.Events[fsnotify.Remove])
-        }
-        if recordMask & uint(fsnotify.Rename) == uint(fsnotify.Rename) {
-            fmt.Printf(numColumn, v.Events[fsnotify.Rename])
-        }
-        if recordMask & uint(fsnotify.Chmod) == uint(fsnotify.Chmod) {
-            fmt.Printf(numColumn, v.Events[fsnotify.Chmod])
+func DoSummary(box *eventbox.EventBox, capture Capture, opts Options) error {
+
+    // events may still be arriving, work from a consistent copy
+    box = box.Snapshot()
+
+    recordMask := capture.RecordMask
+    sortByName := opts.SortByName
+    showTimes := opts.ShowTimes
+    exportCSV := opts.ExportCSV
+    out := opts.Output
+    if out == nil { out = os.Stdout }
+
+    fmt.Fprintln(out)
+
+    if box.Overflows > 0 {
+        fmt.Fprintln(out, overflowWarning(box.Overflows))
+        fmt.Fprintln(out)
+    }
+
+    ops := RecordedOps(recordMask)
+
+    for _, op := range ops {
+        fmt.Fprintf(out, "%-*s", ColumnWidth(op), fileevents.OpName(op))
+    }
+    if showTimes {
+        fmt.Fprintf(out, "%-*s%-*s", len(timeColumnFormat) + 1, "FirstSeen", len(timeColumnFormat) + 1, "LastSeen")
+    }
+    fmt.Fprintln(out, "Path")
+
+    fevents := SortedFiles(box, sortByName)
+
+    for _, v := range fevents {
+        for _, op := range ops {
+            fmt.Fprintf(out, "%-*d", ColumnWidth(op), v.Events[op])
         }
-        if recordMask & uint(fsnotify.Open) == uint(fsnotify.Open) {
-            fmt.Printf(numColumn, v.Events[fsnotify.Open])
+        if showTimes {
+            fmt.Fprintf(out, "%s %s ", v.FirstSeen.Format(timeColumnFormat), v.LastSeen.Format(timeColumnFormat))
         }
-        fmt.Println(v.Name)
+        fmt.Fprintln(out, v.Name)
     }
 
     if len(fevents) == 0 {
-        fmt.Println("No events recorded.")
+        fmt.Fprintln(out, "No events recorded.")
     }
 
-    if exportCSV != "" {
-        fmt.Println("Writing CSV to", exportCSV)
-
-        content := ""
-
-        if recordMask & uint(fsnotify.Create) == uint(fsnotify.Create) {
-            content += "Create,"
+    if len(box.Moves) > 0 {
+        fmt.Fprintln(out)
+        fmt.Fprintln(out, "Moves:")
+        for _, m := range box.Moves {
+            fmt.Fprintln(out, m.String())
         }
-        if recordMask & uint(fsnotify.Write) == uint(fsnotify.Write) {
-            content += "Write,"
+    }
+
+    printedProcessHeader := false
+    for _, v := range fevents {
+        if len(v.Processes) == 0 { continue }
+        if printedProcessHeader == false {
+            fmt.Fprintln(out)
+            fmt.Fprintln(out, "Processes:")
+            printedProcessHeader = true
         }
-        if recordMask & uint(fsnotify.Remove) == uint(fsnotify.Remove) {
-            content += "Remove,"
+        fmt.Fprintln(out, v.Name)
+        for _, p := range sortedProcesses(v.Processes) {
+            fmt.Fprintf(out, "    %-7d%s\n", v.Processes[p], p)
         }
-        if recordMask & uint(fsnotify.Rename) == uint(fsnotify.Rename) {
-            content += "Rename,"
+    }
+
+    if exportCSV != "" {
+        fmt.Fprintln(out, "Writing CSV to", exportCSV)
+
+        // the csv stays plain for other tools, the json exports carry the overflow count
+        if box.Overflows > 0 {
+            fmt.Fprintf(os.Stderr, "WARNING: the inotify queue overflowed %d times, the counts in %s are incomplete.\n", box.Overflows, exportCSV)
         }
-        if recordMask & uint(fsnotify.Chmod) == uint(fsnotify.Chmod) {
-            content += "Chmod,"
+
+        content := ""
+        for _, op := range ops {
+            content += fileevents.OpName(op) + ","
         }
-        if recordMask & uint(fsnotify.Open) == uint(fsnotify.Open) {
-            content += "Open,"
+        if showTimes {
+            content += "FirstSeen,LastSeen,"
         }
         content += "Path\n"
 
         for _, v := range fevents {
-            if recordMask & uint(fsnotify.Create) == uint(fsnotify.Create) {
-                content += strconv.Itoa(int(v.Events[fsnotify.Create])) + ","
-            }
-            if recordMask & uint(fsnotify.Write) == uint(fsnotify.Write) {
-                content += strconv.Itoa(int(v.Events[fsnotify.Write])) + ","
+            for _, op := range ops {
+                content += strconv.Itoa(int(v.Events[op])) + ","
             }
-            if recordMask & uint(fsnotify.Remove) == uint(fsnotify.Remove) {
-                content += strconv.Itoa(int(v.Events[fsnotify.Remove])) + ","
-            }
-            if recordMask & uint(fsnotify.Rename) == uint(fsnotify.Rename) {
-                content += strconv.Itoa(int(v.Events[fsnotify.Rename])) + ","
-            }
-            if recordMask & uint(fsnotify.Chmod) == uint(fsnotify.Chmod) {
-                content += strconv.Itoa(int(v.Events[fsnotify.Chmod])) + ","
-            }
-            if recordMask & uint(fsnotify.Open) == uint(fsnotify.Open) {
-                content += strconv.Itoa(int(v.Events[fsnotify.Open])) + ","
+            if showTimes {
+                content += v.FirstSeen.Format(time.RFC3339Nano) + "," + v.LastSeen.Format(time.RFC3339Nano) + ","
             }
             content += v.Name + "\n"
         }
@@ -137,5 +207,80 @@ func DoSummary(box *eventbox.EventBox, recordMask uint, sortByName bool, exportC
             return err
         }
     }
+
+    if opts.ExportJSON != "" {
+        fmt.Fprintln(out, "Writing JSON to", opts.ExportJSON)
+        err := writeJSON(opts.ExportJSON, box, capture, fevents)
+        if err != nil {
+            return err
+        }
+    }
+
+    if opts.ExportNDJSON != "" {
+        fmt.Fprintln(out, "Writing NDJSON to", opts.ExportNDJSON)
+        err := writeNDJSON(opts.ExportNDJSON, box, capture, fevents)
+        if err != nil {
+            return err
+        }
+    }
     return nil
 }
+
+// ReadCSV loads a summary previously written with -export-csv. Only the op
+// columns present in the file are filled in, and are returned as a mask.
+func ReadCSV(path string) ([]fileevents.FileWithEvents, uint, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil { return nil, 0, err }
+
+    var header []string
+    var opMask uint
+    var fevents []fileevents.FileWithEvents
+    for i, line := range strings.Split(string(content), "\n") {
+        if line == "" || strings.HasPrefix(line, "#") { continue }
+
+        if header == nil {
+            header = strings.Split(line, ",")
+            if header[len(header) - 1] != "Path" {
+                return nil, 0, fmt.Errorf("%s:%d: not an inotify-spy csv export", path, i + 1)
+            }
+            for _, column := range header[:len(header) - 1] {
+                op, ok := fileevents.OpByName(column)
+                if ok { opMask |= uint(op) }
+            }
+            continue
+        }
+
+        // the path is last and may itself contain commas
+        fields := strings.SplitN(line, ",", len(header))
+        if len(fields) != len(header) {
+            return nil, 0, fmt.Errorf("%s:%d: expected %d columns", path, i + 1, len(header))
+        }
+
+        v := fileevents.FileWithEvents{
+            Name: fields[len(fields) - 1],
+            Events: make(map[fsnotify.Op]int),
+        }
+        for j, column := range header[:len(header) - 1] {
+            switch column {
+            case "FirstSeen":
+                v.FirstSeen, err = time.Parse(time.RFC3339Nano, fields[j])
+            case "LastSeen":
+                v.LastSeen, err = time.Parse(time.RFC3339Nano, fields[j])
+            default:
+                op, ok := fileevents.OpByName(column)
+                if ok == false {
+                    return nil, 0, fmt.Errorf("%s:%d: unknown column %q", path, i + 1, column)
+                }
+                var count int
+                count, err = strconv.Atoi(fields[j])
+                v.Events[op] = count
+                v.Total += count
+            }
+            if err != nil {
+                return nil, 0, fmt.Errorf("%s:%d: %s", path, i + 1, err.Error())
+            }
+        }
+        fevents = append(fevents, v)
+    }
+    return fevents, opMask, nil
+}
@@ -0,0 +1,125 @@
+package summary
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    "github.com/fsnotify/fsnotify"
+)
+
+func writeTemp(t *testing.T, dir string, name string, content string) string {
+    path := filepath.Join(dir, name)
+    if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil { t.Fatal(err) }
+    return path
+}
+
+func TestReadCSV(t *testing.T) {
+    dir, err := ioutil.TempDir("", "summary")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(dir)
+
+    first := time.Date(2024, 3, 1, 10, 0, 0, 500, time.UTC)
+    last := time.Date(2024, 3, 1, 10, 0, 5, 0, time.UTC)
+    tests := []struct {
+        name string
+        content string
+        wantMask uint
+        wantName string
+        wantCounts map[fsnotify.Op]int
+        wantTotal int
+        wantTimes bool
+    }{
+        {
+            name: "plain",
+            content: "Create,Write,Path\n1,2,/tmp/a\n",
+            wantMask: uint(fsnotify.Create | fsnotify.Write),
+            wantName: "/tmp/a",
+            wantCounts: map[fsnotify.Op]int{fsnotify.Create: 1, fsnotify.Write: 2},
+            wantTotal: 3,
+        },
+        {
+            name: "path with commas",
+            content: "Write,Path\n4,/tmp/a,b,,c.txt\n",
+            wantMask: uint(fsnotify.Write),
+            wantName: "/tmp/a,b,,c.txt",
+            wantCounts: map[fsnotify.Op]int{fsnotify.Write: 4},
+            wantTotal: 4,
+        },
+        {
+            name: "time columns",
+            content: "Open,FirstSeen,LastSeen,Path\n1," + first.Format(time.RFC3339Nano) + "," + last.Format(time.RFC3339Nano) + ",/tmp/x,y\n",
+            wantMask: uint(fsnotify.Open),
+            wantName: "/tmp/x,y",
+            wantCounts: map[fsnotify.Op]int{fsnotify.Open: 1},
+            wantTotal: 1,
+            wantTimes: true,
+        },
+        {
+            name: "comment lines from older exports are skipped",
+            content: "# WARNING: the inotify queue overflowed\nCloseWrite,Path\n1,/tmp/a\n",
+            wantMask: uint(fsnotify.CloseWrite),
+            wantName: "/tmp/a",
+            wantCounts: map[fsnotify.Op]int{fsnotify.CloseWrite: 1},
+            wantTotal: 1,
+        },
+    }
+
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := writeTemp(t, dir, string(rune('a' + i)) + ".csv", tt.content)
+            fevents, mask, err := ReadCSV(path)
+            if err != nil { t.Fatal(err) }
+            if mask != tt.wantMask {
+                t.Errorf("mask %d, want %d", mask, tt.wantMask)
+            }
+            if len(fevents) != 1 {
+                t.Fatalf("got %d files, want 1", len(fevents))
+            }
+            f := fevents[0]
+            if f.Name != tt.wantName {
+                t.Errorf("name %q, want %q", f.Name, tt.wantName)
+            }
+            if f.Total != tt.wantTotal {
+                t.Errorf("total %d, want %d", f.Total, tt.wantTotal)
+            }
+            for op, want := range tt.wantCounts {
+                if f.Events[op] != want {
+                    t.Errorf("%v: %d, want %d", op, f.Events[op], want)
+                }
+            }
+            if tt.wantTimes && (f.FirstSeen.Equal(first) == false || f.LastSeen.Equal(last) == false) {
+                t.Errorf("times %v - %v, want %v - %v", f.FirstSeen, f.LastSeen, first, last)
+            }
+        })
+    }
+}
+
+func TestReadCSVErrors(t *testing.T) {
+    dir, err := ioutil.TempDir("", "summary")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(dir)
+
+    tests := []struct {
+        name string
+        content string
+        want string
+    }{
+        {"not an export", "a,b,c\n1,2,3\n", "not an inotify-spy csv export"},
+        {"unknown column", "Bogus,Path\n1,/tmp/a\n", "unknown column"},
+        {"short line", "Create,Write,Path\n1,/tmp/a\n", "expected 3 columns"},
+        {"bad count", "Create,Path\nx,/tmp/a\n", "invalid syntax"},
+        {"bad time", "FirstSeen,Path\nyesterday,/tmp/a\n", "cannot parse"},
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := writeTemp(t, dir, string(rune('a' + i)) + ".csv", tt.content)
+            _, _, err := ReadCSV(path)
+            if err == nil || strings.Contains(err.Error(), tt.want) == false {
+                t.Errorf("got error %v, want one mentioning %q", err, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "os"
+    "os/exec"
+    "syscall"
+)
+
+// wrappedCommand is the command given after -- that the capture runs for.
+type wrappedCommand struct {
+    cmd *exec.Cmd
+
+    // Done receives the exit code of the command once it exits.
+    Done chan int
+}
+
+func startWrappedCommand(args []string) (*wrappedCommand, error) {
+    cmd := exec.Command(args[0], args[1:]...)
+    cmd.Stdin = os.Stdin
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+
+    err := cmd.Start()
+    if err != nil { return nil, err }
+
+    w := &wrappedCommand{cmd: cmd, Done: make(chan int, 1)}
+    go func() {
+        w.Done <- exitCode(cmd.Wait())
+    }()
+    return w, nil
+}
+
+// Signal passes a signal on to the command, it is up to the command whether it exits.
+func (w *wrappedCommand) Signal(sig os.Signal) {
+    w.cmd.Process.Signal(sig)
+}
+
+// exitCode turns the result of waiting for a command into an exit code, using
+// the shell convention of 128 plus the signal number for killed commands.
+func exitCode(err error) int {
+    if err == nil { return 0 }
+    exitErr, ok := err.(*exec.ExitError)
+    if ok == false { return 1 }
+    status, ok := exitErr.Sys().(syscall.WaitStatus)
+    if ok == false { return 1 }
+    if status.Signaled() { return 128 + int(status.Signal()) }
+    return status.ExitStatus()
+}